@@ -0,0 +1,461 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+type Cardinality string
+
+const (
+	CardinalityOne  Cardinality = "one"
+	CardinalityMany Cardinality = "many"
+)
+
+const (
+	OperationGetItem = "GetItem"
+	OperationQuery   = "Query"
+	OperationScan    = "Scan"
+)
+
+// AccessPattern describes a single read access pattern of the repository.
+//
+// KeyCondition is the KeyConditionExpression used by the repository; #name placeholders refer to the attribute
+// of the same name. PartitionKey is a template of the partition key value, e.g. employees#{employeeID}.
+type AccessPattern struct {
+	Name         string      `json:"name"`
+	EntityTypes  []string    `json:"entityTypes"`
+	Operation    string      `json:"operation"`
+	IndexName    string      `json:"indexName,omitempty"`
+	KeyCondition string      `json:"keyCondition"`
+	PartitionKey string      `json:"partitionKey"`
+	Cardinality  Cardinality `json:"cardinality"`
+}
+
+// EntityKeys describes the key attributes an entity type writes, as templates of their values.
+// A template is a literal string with {attribute} placeholders. A placeholder of a missing attribute is written
+// as MissingKeyPart.
+type EntityKeys struct {
+	EntityType string            `json:"entityType"`
+	Keys       map[string]string `json:"keys"`
+}
+
+func AccessPatterns() []*AccessPattern {
+	return []*AccessPattern{
+		{
+			Name:         "GetEmployee",
+			EntityTypes:  []string{employeePrefix},
+			Operation:    OperationQuery,
+			KeyCondition: "pk=:pk",
+			PartitionKey: "employees#{employeeID}",
+			Cardinality:  CardinalityOne,
+		},
+		{
+			Name:         "GetIdempotencyRecord",
+			EntityTypes:  []string{idempotencyPrefix},
+			Operation:    OperationGetItem,
+			KeyCondition: "pk=:pk AND sk=:sk",
+			PartitionKey: "idempotency#{key}",
+			Cardinality:  CardinalityOne,
+		},
+		{
+			Name:         "GetEmployeeDirectReports",
+			EntityTypes:  []string{employeePrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "employees#{reportsTo}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetProductsDiscontinued",
+			EntityTypes:  []string{sparseIndexPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "sparse#discontinued-products",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetCustomersWithoutFax",
+			EntityTypes:  []string{sparseIndexPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "sparse#customers-without-fax",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetOrdersAwaitingShipment",
+			EntityTypes:  []string{sparseIndexPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "sparse#orders-awaiting-shipment",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetOrdersOfProduct",
+			EntityTypes:  []string{orderDetailPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "products#{productID}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetOrdersRecent",
+			EntityTypes:  []string{orderPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "ORDER",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetOrdersOfCustomer",
+			EntityTypes:  []string{orderPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_2",
+			KeyCondition: "#data=:data AND sk=:sk",
+			PartitionKey: "{customerID}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetShippersByName",
+			EntityTypes:  []string{shipperPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "shippers#{companyName}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetCustomersByContactName",
+			EntityTypes:  []string{customerPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk",
+			PartitionKey: "customers#{contactName}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetProductsInOrder",
+			EntityTypes:  []string{orderDetailPrefix},
+			Operation:    OperationQuery,
+			KeyCondition: "pk=:pk AND begins_with(sk,:sk)",
+			PartitionKey: "{orderID}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetOrderLinesByValue",
+			EntityTypes:  []string{orderDetailPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "lsi_1",
+			KeyCondition: "pk=:pk",
+			PartitionKey: "{orderID}",
+			Cardinality:  CardinalityMany,
+		},
+		{
+			Name:         "GetSuppliersByCountry",
+			EntityTypes:  []string{supplierPrefix},
+			Operation:    OperationQuery,
+			IndexName:    "gsi_1",
+			KeyCondition: "sk=:sk AND begins_with(#data,:data)",
+			PartitionKey: "SUPPLIER",
+			Cardinality:  CardinalityMany,
+		},
+	}
+}
+
+func EntityKeySchemas() []*EntityKeys {
+	return []*EntityKeys{
+		{
+			EntityType: categoryPrefix,
+			Keys:       map[string]string{"pk": "categories#{categoryID}", "sk": "categories#{categoryName}", "data": "{description}"},
+		},
+		{
+			EntityType: customerPrefix,
+			Keys:       map[string]string{"pk": "customers#{customerID}", "sk": "customers#{contactName}", "data": "{country}#{region}#{city}#{address}"},
+		},
+		{
+			EntityType: employeePrefix,
+			Keys:       map[string]string{"pk": "employees#{employeeID}", "sk": "employees#{reportsTo}", "data": "{hireDate}"},
+		},
+		{
+			EntityType: idempotencyPrefix,
+			Keys:       map[string]string{"pk": "idempotency#{key}", "sk": "IDEMPOTENCY"},
+		},
+		{
+			EntityType: orderDetailPrefix,
+			Keys:       map[string]string{"pk": "{orderID}", "sk": "products#{productID}", "data": "{unitPrice}", "lsi_1_sk": "{lineValue}"},
+		},
+		{
+			EntityType: orderPrefix,
+			Keys:       map[string]string{"pk": "{orderID}", "sk": "ORDER", "data": "{customerID}"},
+		},
+		{
+			EntityType: productPrefix,
+			Keys:       map[string]string{"pk": "products#{productID}", "sk": "PRODUCT"},
+		},
+		{
+			EntityType: shipperPrefix,
+			Keys:       map[string]string{"pk": "shippers#{shipperID}", "sk": "shippers#{companyName}", "data": "{phone}"},
+		},
+		{
+			EntityType: supplierPrefix,
+			Keys:       map[string]string{"pk": "suppliers#{supplierID}", "sk": "SUPPLIER", "data": "{country}#{region}#{city}#{address}"},
+		},
+		{
+			EntityType: sparseIndexPrefix,
+			Keys:       map[string]string{"pk": "{pk}", "sk": "sparse#{indexName}", "data": "{pk}"},
+		},
+		{
+			EntityType: metaPrefix,
+			Keys:       map[string]string{"pk": "_meta#{name}", "sk": "_meta#{name}"},
+		},
+	}
+}
+
+// ValidateAccessPatterns checks that every access pattern is served by a key condition on an index of the table
+// schema, that the access patterns and the Get methods of the repository match one to one and that no two entity
+// types share the partition key space of gsi_1.
+func ValidateAccessPatterns(schema *TableSchema, patterns []*AccessPattern, entities []*EntityKeys) error {
+	var violations []string
+
+	entitiesByType := map[string]*EntityKeys{}
+	for _, entity := range entities {
+		entitiesByType[entity.EntityType] = entity
+	}
+
+	for _, pattern := range patterns {
+		for _, violation := range validateAccessPattern(schema, pattern, entitiesByType) {
+			violations = append(violations, fmt.Sprintf("access pattern %v: %v", pattern.Name, violation))
+		}
+	}
+
+	violations = append(violations, repositoryQueryMismatches(patterns)...)
+	violations = append(violations, keySpaceCollisions(schema, "gsi_1", entities)...)
+
+	if len(violations) > 0 {
+		return fmt.Errorf("invalid access patterns:\n  %v", strings.Join(violations, "\n  "))
+	}
+	return nil
+}
+
+func validateAccessPattern(schema *TableSchema, pattern *AccessPattern, entitiesByType map[string]*EntityKeys) []string {
+	var violations []string
+
+	if pattern.Operation != OperationQuery && pattern.Operation != OperationGetItem {
+		violations = append(violations, fmt.Sprintf("operation %v is not served by a key condition", pattern.Operation))
+	}
+	if pattern.Cardinality != CardinalityOne && pattern.Cardinality != CardinalityMany {
+		violations = append(violations, fmt.Sprintf("unknown cardinality %q", pattern.Cardinality))
+	}
+
+	index := schema.Index(pattern.IndexName)
+	if index == nil {
+		return append(violations, fmt.Sprintf("unknown index %v", pattern.IndexName))
+	}
+
+	conditions, err := parseKeyCondition(pattern.KeyCondition)
+	if err != nil {
+		return append(violations, err.Error())
+	}
+	if conditions[index.PartitionKey] != "=" {
+		violations = append(violations, fmt.Sprintf("key condition %q has no equality condition on the partition key %v", pattern.KeyCondition, index.PartitionKey))
+	}
+	for attribute := range conditions {
+		if attribute != index.PartitionKey && attribute != index.SortKey {
+			violations = append(violations, fmt.Sprintf("key condition %q uses the non-key attribute %v", pattern.KeyCondition, attribute))
+		}
+	}
+
+	if len(pattern.EntityTypes) == 0 {
+		violations = append(violations, "no entity types")
+	}
+	for _, entityType := range pattern.EntityTypes {
+		entity, ok := entitiesByType[entityType]
+		if !ok {
+			violations = append(violations, fmt.Sprintf("unknown entity type %v", entityType))
+			continue
+		}
+		if !keyTemplatesOverlap(pattern.PartitionKey, entity.Keys[index.PartitionKey]) {
+			violations = append(violations, fmt.Sprintf("partition key %q does not match the %v key %q of %v", pattern.PartitionKey, index.PartitionKey, entity.Keys[index.PartitionKey], entityType))
+		}
+	}
+
+	return violations
+}
+
+// repositoryQueryMismatches reports access patterns without a repository method of the same name and Get methods of
+// the repository without an access pattern
+func repositoryQueryMismatches(patterns []*AccessPattern) []string {
+	var violations []string
+	named := map[string]bool{}
+	repositoryType := reflect.TypeOf(&Repository{})
+	for _, pattern := range patterns {
+		named[pattern.Name] = true
+		if _, ok := repositoryType.MethodByName(pattern.Name); !ok {
+			violations = append(violations, fmt.Sprintf("access pattern %v: no repository method %v", pattern.Name, pattern.Name))
+		}
+	}
+	for i := 0; i < repositoryType.NumMethod(); i++ {
+		name := repositoryType.Method(i).Name
+		if strings.HasPrefix(name, "Get") && !named[name] {
+			violations = append(violations, fmt.Sprintf("repository method %v has no access pattern", name))
+		}
+	}
+	return violations
+}
+
+func keySpaceCollisions(schema *TableSchema, indexName string, entities []*EntityKeys) []string {
+	index := schema.Index(indexName)
+	if index == nil {
+		return []string{fmt.Sprintf("unknown index %v", indexName)}
+	}
+	hashKey := index.PartitionKey
+
+	var violations []string
+	for i, entity := range entities {
+		for _, other := range entities[i+1:] {
+			template, otherTemplate := entity.Keys[hashKey], other.Keys[hashKey]
+			if template == "" || otherTemplate == "" {
+				continue
+			}
+			if keyTemplatesOverlap(template, otherTemplate) {
+				violations = append(violations, fmt.Sprintf("entity types %v (%v=%q) and %v (%v=%q) collide in %v", entity.EntityType, hashKey, template, other.EntityType, hashKey, otherTemplate, indexName))
+			}
+		}
+	}
+	return violations
+}
+
+var keyConditionSeparator = regexp.MustCompile(`(?i)\s+and\s+`)
+
+var keyConditionClause = regexp.MustCompile(`^(?:begins_with\(\s*(#?\w+)\s*,\s*:\w+\s*\)|(#?\w+)\s*(=|<=|>=|<|>)\s*:\w+|(#?\w+)\s+(?i:between)\s+:\w+\s+(?i:and)\s+:\w+)$`)
+
+// parseKeyCondition returns the compared attributes of a key condition expression with their operators
+func parseKeyCondition(keyCondition string) (map[string]string, error) {
+	if strings.TrimSpace(keyCondition) == "" {
+		return nil, fmt.Errorf("no key condition")
+	}
+
+	// BETWEEN contains an AND itself, so the clauses are joined back before matching
+	conditions := map[string]string{}
+	parts := keyConditionSeparator.Split(strings.TrimSpace(keyCondition), -1)
+	for i := 0; i < len(parts); i++ {
+		clause := parts[i]
+		if strings.Contains(strings.ToLower(clause), " between ") && i+1 < len(parts) {
+			clause = clause + " AND " + parts[i+1]
+			i++
+		}
+
+		match := keyConditionClause.FindStringSubmatch(strings.TrimSpace(clause))
+		switch {
+		case match == nil:
+			return nil, fmt.Errorf("unsupported key condition clause %q", clause)
+		case match[1] != "":
+			conditions[strings.TrimPrefix(match[1], "#")] = "begins_with"
+		case match[2] != "":
+			conditions[strings.TrimPrefix(match[2], "#")] = match[3]
+		default:
+			conditions[strings.TrimPrefix(match[4], "#")] = "between"
+		}
+	}
+
+	return conditions, nil
+}
+
+// keyTemplatesOverlap reports whether two key templates can produce the same value. Only the literal part in
+// front of the first placeholder is known, so templates overlap when one literal prefix is a prefix of the other.
+func keyTemplatesOverlap(template string, other string) bool {
+	prefix, constant := templatePrefix(template)
+	otherPrefix, otherConstant := templatePrefix(other)
+
+	switch {
+	case constant && otherConstant:
+		return prefix == otherPrefix
+	case constant:
+		return strings.HasPrefix(prefix, otherPrefix)
+	case otherConstant:
+		return strings.HasPrefix(otherPrefix, prefix)
+	default:
+		return strings.HasPrefix(prefix, otherPrefix) || strings.HasPrefix(otherPrefix, prefix)
+	}
+}
+
+func templatePrefix(template string) (string, bool) {
+	index := strings.Index(template, "{")
+	if index < 0 {
+		return template, true
+	}
+	return template[:index], false
+}
+
+// EntityClassifier tells the entity type of an item by matching its primary key against the key templates
+type EntityClassifier struct {
+	entities []classifiedEntity
+}
+
+type classifiedEntity struct {
+	entityType string
+	keys       map[string]*regexp.Regexp
+	literals   int
+}
+
+var keyTemplatePlaceholder = regexp.MustCompile(`\{\w+\}`)
+
+func NewEntityClassifier(entities []*EntityKeys) *EntityClassifier {
+	classifier := &EntityClassifier{}
+	for _, entity := range entities {
+		classified := classifiedEntity{
+			entityType: entity.EntityType,
+			keys:       map[string]*regexp.Regexp{},
+		}
+		for _, attribute := range []string{"pk", "sk"} {
+			template := entity.Keys[attribute]
+			classified.keys[attribute] = keyTemplateRegexp(template)
+			classified.literals += len(keyTemplatePlaceholder.ReplaceAllString(template, ""))
+		}
+		classifier.entities = append(classifier.entities, classified)
+	}
+	return classifier
+}
+
+// EntityType returns the entity type whose key templates match the primary key of the item. If the templates of
+// several entity types match, the one with the most literal characters in its templates wins.
+func (c *EntityClassifier) EntityType(item map[string]*dynamodb.AttributeValue) string {
+	entityType, bestLiterals := "", -1
+	for _, entity := range c.entities {
+		matches := true
+		for attribute, key := range entity.keys {
+			value := item[attribute]
+			if value == nil || value.S == nil || !key.MatchString(*value.S) {
+				matches = false
+				break
+			}
+		}
+		if matches && entity.literals > bestLiterals {
+			entityType, bestLiterals = entity.entityType, entity.literals
+		}
+	}
+	return entityType
+}
+
+func keyTemplateRegexp(template string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, match := range keyTemplatePlaceholder.FindAllStringIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[last:match[0]]))
+		pattern.WriteString(".*")
+		last = match[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
@@ -0,0 +1,247 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// BackupRetention prunes on-demand backups which are older than MaxAge or exceed the newest MaxCount backups. A zero
+// value disables the respective limit.
+type BackupRetention struct {
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+// CreateBackup creates an on-demand backup of the table and waits until it is available
+func (r *TableManager) CreateBackup(backupName string) (*dynamodb.BackupDetails, error) {
+	logger := log.WithFields(log.Fields{
+		"table":  r.tableName,
+		"backup": backupName,
+	})
+	logger.Info("Creating a backup of the dynamoDB table")
+
+	output, err := r.dynamoDBClient.CreateBackup(&dynamodb.CreateBackupInput{
+		TableName:  aws.String(r.tableName),
+		BackupName: aws.String(backupName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create backup of dynamoDB table %v: %v", r.tableName, err)
+	}
+
+	backupArn := output.BackupDetails.BackupArn
+	for aws.StringValue(output.BackupDetails.BackupStatus) == dynamodb.BackupStatusCreating {
+		time.Sleep(tableStatusPollInterval)
+
+		described, err := r.dynamoDBClient.DescribeBackup(&dynamodb.DescribeBackupInput{
+			BackupArn: backupArn,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not describe backup %v: %v", aws.StringValue(backupArn), err)
+		}
+		output.BackupDetails = described.BackupDescription.BackupDetails
+	}
+	if status := aws.StringValue(output.BackupDetails.BackupStatus); status != dynamodb.BackupStatusAvailable {
+		return nil, fmt.Errorf("backup %v of dynamoDB table %v is %v", aws.StringValue(backupArn), r.tableName, status)
+	}
+
+	logger.WithField("arn", aws.StringValue(backupArn)).Info("Created backup")
+
+	return output.BackupDetails, nil
+}
+
+// ListBackups returns the on-demand backups of the table created by users, oldest first
+func (r *TableManager) ListBackups() ([]*dynamodb.BackupSummary, error) {
+	var backups []*dynamodb.BackupSummary
+	input := &dynamodb.ListBackupsInput{
+		TableName:  aws.String(r.tableName),
+		BackupType: aws.String(dynamodb.BackupTypeFilterUser),
+	}
+	for {
+		output, err := r.dynamoDBClient.ListBackups(input)
+		if err != nil {
+			return nil, fmt.Errorf("could not list backups of dynamoDB table %v: %v", r.tableName, err)
+		}
+		backups = append(backups, output.BackupSummaries...)
+		if output.LastEvaluatedBackupArn == nil {
+			break
+		}
+		input.ExclusiveStartBackupArn = output.LastEvaluatedBackupArn
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return aws.TimeValue(backups[i].BackupCreationDateTime).Before(aws.TimeValue(backups[j].BackupCreationDateTime))
+	})
+	return backups, nil
+}
+
+func (r *TableManager) DeleteBackup(backupArn string) error {
+	log.WithFields(log.Fields{
+		"table": r.tableName,
+		"arn":   backupArn,
+	}).Info("Deleting backup")
+
+	_, err := r.dynamoDBClient.DeleteBackup(&dynamodb.DeleteBackupInput{
+		BackupArn: aws.String(backupArn),
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete backup %v: %v", backupArn, err)
+	}
+	return nil
+}
+
+// PruneBackups deletes the available backups which fall outside of the retention and returns them
+func (r *TableManager) PruneBackups(retention BackupRetention) ([]*dynamodb.BackupSummary, error) {
+	backups, err := r.ListBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	var available []*dynamodb.BackupSummary
+	for _, backup := range backups {
+		if aws.StringValue(backup.BackupStatus) == dynamodb.BackupStatusAvailable {
+			available = append(available, backup)
+		}
+	}
+
+	var pruned []*dynamodb.BackupSummary
+	now := time.Now()
+	for i, backup := range available {
+		tooOld := retention.MaxAge > 0 && now.Sub(aws.TimeValue(backup.BackupCreationDateTime)) > retention.MaxAge
+		tooMany := retention.MaxCount > 0 && len(available)-i > retention.MaxCount
+		if !tooOld && !tooMany {
+			continue
+		}
+		err := r.DeleteBackup(aws.StringValue(backup.BackupArn))
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, backup)
+	}
+
+	log.WithFields(log.Fields{
+		"table":  r.tableName,
+		"pruned": len(pruned),
+		"kept":   len(available) - len(pruned),
+	}).Info("Pruned backups")
+
+	return pruned, nil
+}
+
+// RestoreBackup restores a backup into a new table and waits until the table is active. Time to live is not part
+// of a backup and is enabled again as defined in the schema.
+func (r *TableManager) RestoreBackup(backupArn string, targetTableName string) error {
+	log.WithFields(log.Fields{
+		"arn":    backupArn,
+		"target": targetTableName,
+	}).Info("Restoring backup")
+
+	_, err := r.dynamoDBClient.RestoreTableFromBackup(&dynamodb.RestoreTableFromBackupInput{
+		BackupArn:       aws.String(backupArn),
+		TargetTableName: aws.String(targetTableName),
+	})
+	if err != nil {
+		return fmt.Errorf("could not restore backup %v to dynamoDB table %v: %v", backupArn, targetTableName, err)
+	}
+
+	return r.waitForRestore(targetTableName)
+}
+
+// EnablePITR enables point in time recovery of the table
+func (r *TableManager) EnablePITR() error {
+	log.WithField("table", r.tableName).Info("Enabling point in time recovery of the dynamoDB table")
+
+	_, err := r.dynamoDBClient.UpdateContinuousBackups(&dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(r.tableName),
+		PointInTimeRecoverySpecification: &dynamodb.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not enable point in time recovery of dynamoDB table %v: %v", r.tableName, err)
+	}
+	return nil
+}
+
+func (r *TableManager) DescribePITR() (*dynamodb.PointInTimeRecoveryDescription, error) {
+	output, err := r.dynamoDBClient.DescribeContinuousBackups(&dynamodb.DescribeContinuousBackupsInput{
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe point in time recovery of dynamoDB table %v: %v", r.tableName, err)
+	}
+	if output.ContinuousBackupsDescription == nil || output.ContinuousBackupsDescription.PointInTimeRecoveryDescription == nil {
+		return &dynamodb.PointInTimeRecoveryDescription{
+			PointInTimeRecoveryStatus: aws.String(dynamodb.PointInTimeRecoveryStatusDisabled),
+		}, nil
+	}
+	return output.ContinuousBackupsDescription.PointInTimeRecoveryDescription, nil
+}
+
+// RestorePointInTime restores the table as of the given time into a new table and waits until the table is active.
+// A zero time restores the latest restorable time.
+func (r *TableManager) RestorePointInTime(targetTableName string, restoreTime time.Time) error {
+	input := &dynamodb.RestoreTableToPointInTimeInput{
+		SourceTableName: aws.String(r.tableName),
+		TargetTableName: aws.String(targetTableName),
+	}
+	if restoreTime.IsZero() {
+		input.UseLatestRestorableTime = aws.Bool(true)
+	} else {
+		input.RestoreDateTime = aws.Time(restoreTime)
+	}
+
+	log.WithFields(log.Fields{
+		"table":  r.tableName,
+		"target": targetTableName,
+		"time":   restoreTime,
+	}).Info("Restoring the dynamoDB table to a point in time")
+
+	_, err := r.dynamoDBClient.RestoreTableToPointInTime(input)
+	if err != nil {
+		return fmt.Errorf("could not restore dynamoDB table %v to %v: %v", r.tableName, targetTableName, err)
+	}
+
+	return r.waitForRestore(targetTableName)
+}
+
+func (r *TableManager) waitForRestore(targetTableName string) error {
+	logger := log.WithField("table", targetTableName)
+	target := NewTableManager(r.dynamoDBClient, targetTableName, r.schema)
+
+	started := time.Now()
+	for {
+		time.Sleep(tableStatusPollInterval)
+
+		table, err := target.describeTable()
+		if err != nil {
+			return err
+		}
+
+		logger.WithFields(log.Fields{
+			"status":  aws.StringValue(table.TableStatus),
+			"elapsed": time.Since(started).Round(time.Second).String(),
+		}).Info("Waiting for restore")
+
+		if aws.StringValue(table.TableStatus) == dynamodb.TableStatusActive {
+			break
+		}
+		if time.Since(started) >= r.restoreTimeout {
+			return fmt.Errorf("restored dynamoDB table %v is not active after %v", targetTableName, r.restoreTimeout)
+		}
+	}
+
+	if r.schema.TimeToLiveAttribute != "" {
+		err := target.EnableTTL()
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Restored table")
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"time"
+)
+
+const (
+	batchWriteItemLimit   = 25
+	batchWriteItemRetries = 8
+)
+
+// BatchWriter buffers puts and deletes and writes them with BatchWriteItem, retrying unprocessed items with
+// exponential backoff. Writes can be labeled with the row they belong to, so that the rows of failed writes are
+// known. A BatchWriter must not be used by multiple goroutines.
+type BatchWriter struct {
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+	tableName      string
+	buffer         []*dynamodb.WriteRequest
+	bufferRows     []string
+	row            string
+	failedRows     map[string]error
+	written        int64
+	rateLimiter    *RateLimiter
+}
+
+func NewBatchWriter(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string) *BatchWriter {
+	return &BatchWriter{
+		dynamoDBClient: dynamoDBClient,
+		tableName:      tableName,
+	}
+}
+
+// SetRateLimiter throttles the writes, a rate limiter can be shared by several batch writers
+func (w *BatchWriter) SetRateLimiter(rateLimiter *RateLimiter) {
+	w.rateLimiter = rateLimiter
+}
+
+func (w *BatchWriter) Put(item map[string]*dynamodb.AttributeValue) error {
+	return w.add(&dynamodb.WriteRequest{
+		PutRequest: &dynamodb.PutRequest{
+			Item: item,
+		},
+	})
+}
+
+func (w *BatchWriter) Delete(key map[string]*dynamodb.AttributeValue) error {
+	return w.add(&dynamodb.WriteRequest{
+		DeleteRequest: &dynamodb.DeleteRequest{
+			Key: key,
+		},
+	})
+}
+
+// Written returns the number of items written so far
+func (w *BatchWriter) Written() int64 {
+	return w.written
+}
+
+// SetRow labels the following puts and deletes with the row they belong to
+func (w *BatchWriter) SetRow(row string) {
+	w.row = row
+}
+
+// FailedRows returns the rows with writes which failed since the last call and the error of each row
+func (w *BatchWriter) FailedRows() map[string]error {
+	failedRows := w.failedRows
+	w.failedRows = nil
+	return failedRows
+}
+
+func (w *BatchWriter) add(writeRequest *dynamodb.WriteRequest) error {
+	w.buffer = append(w.buffer, writeRequest)
+	w.bufferRows = append(w.bufferRows, w.row)
+	if len(w.buffer) < batchWriteItemLimit {
+		return nil
+	}
+	return w.Flush()
+}
+
+// Flush writes all buffered items. If the batch fails, the items which were not written yet are written one at a
+// time, so that only the rows of the items DynamoDB rejects fail.
+func (w *BatchWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	writeRequests, rows := w.buffer, w.bufferRows
+	w.buffer, w.bufferRows = nil, nil
+
+	unprocessed, err := w.write(writeRequests)
+	if err == nil {
+		return nil
+	}
+	if len(writeRequests) == 1 {
+		w.fail(rows[0], err)
+		return err
+	}
+
+	// Unprocessed items are returned as copies, so they are matched with their rows by content
+	rowsByRequest := map[string][]string{}
+	for i, writeRequest := range writeRequests {
+		key := writeRequestKey(writeRequest)
+		rowsByRequest[key] = append(rowsByRequest[key], rows[i])
+	}
+
+	var firstErr error
+	failed := 0
+	for _, writeRequest := range unprocessed {
+		row := ""
+		key := writeRequestKey(writeRequest)
+		if requestRows := rowsByRequest[key]; len(requestRows) > 0 {
+			row, rowsByRequest[key] = requestRows[0], requestRows[1:]
+		}
+		_, err := w.write([]*dynamodb.WriteRequest{writeRequest})
+		if err != nil {
+			w.fail(row, err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to write %v of %v items: %v", failed, len(writeRequests), firstErr)
+	}
+	return nil
+}
+
+func (w *BatchWriter) fail(row string, err error) {
+	if w.failedRows == nil {
+		w.failedRows = map[string]error{}
+	}
+	if _, ok := w.failedRows[row]; !ok {
+		w.failedRows[row] = err
+	}
+}
+
+// write writes the items with BatchWriteItem, retrying throttled requests and unprocessed items. On error it returns
+// the items which were not written.
+func (w *BatchWriter) write(writeRequests []*dynamodb.WriteRequest) ([]*dynamodb.WriteRequest, error) {
+	backoff := 50 * time.Millisecond
+	for attempt := 0; len(writeRequests) > 0; attempt++ {
+		if attempt > batchWriteItemRetries {
+			return writeRequests, fmt.Errorf("failed to write %v items to dynamodb: retries exhausted", len(writeRequests))
+		}
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		w.rateLimiter.Wait(len(writeRequests))
+
+		output, err := w.dynamoDBClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				w.tableName: writeRequests,
+			},
+		})
+		if err != nil {
+			if isThrottlingError(err) {
+				continue
+			}
+			return writeRequests, fmt.Errorf("failed to write items to dynamodb: %v", err)
+		}
+
+		unprocessed := output.UnprocessedItems[w.tableName]
+		w.written += int64(len(writeRequests) - len(unprocessed))
+		writeRequests = unprocessed
+	}
+
+	return nil, nil
+}
+
+// writeRequestKey identifies a write request by its content, JSON sorts the attribute names
+func writeRequestKey(writeRequest *dynamodb.WriteRequest) string {
+	data, _ := json.Marshal(writeRequest)
+	return string(data)
+}
+
+func isThrottlingError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeRequestLimitExceeded, "ThrottlingException":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// blobAttributeSuffix names the attribute holding the pointer of an offloaded binary attribute, e.g. photoBlob
+	blobAttributeSuffix = "Blob"
+	blobKeyPrefix       = "sha256:"
+)
+
+// BlobStore keeps binary attribute values outside of the table. Blobs are content addressed, storing the same data
+// twice returns the same key.
+type BlobStore interface {
+	Put(data []byte) (string, error)
+	Get(key string) ([]byte, error)
+}
+
+// FileBlobStore stores blobs in a local directory as <directory>/<first two hex digits>/<sha256 hex>
+type FileBlobStore struct {
+	directory string
+}
+
+func NewFileBlobStore(directory string) *FileBlobStore {
+	return &FileBlobStore{
+		directory: directory,
+	}
+}
+
+func (s *FileBlobStore) Put(data []byte) (string, error) {
+	checksum := sha256.Sum256(data)
+	key := blobKeyPrefix + hex.EncodeToString(checksum[:])
+	filename, err := s.filename(key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return key, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return "", fmt.Errorf("could not create blob directory: %v", err)
+	}
+	// Write to a temporary file first, so that a blob is either complete or missing
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".blob-")
+	if err != nil {
+		return "", fmt.Errorf("could not create blob file: %v", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("could not write blob %v: %v", key, err)
+	}
+	return key, nil
+}
+
+func (s *FileBlobStore) Get(key string) ([]byte, error) {
+	filename, err := s.filename(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read blob %v: %v", key, err)
+	}
+	if checksum := sha256.Sum256(data); blobKeyPrefix+hex.EncodeToString(checksum[:]) != key {
+		return nil, fmt.Errorf("blob %v is corrupt", key)
+	}
+	return data, nil
+}
+
+func (s *FileBlobStore) filename(key string) (string, error) {
+	digest := strings.TrimPrefix(key, blobKeyPrefix)
+	if digest == key || len(digest) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob key %v", key)
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", fmt.Errorf("invalid blob key %v", key)
+	}
+	return filepath.Join(s.directory, digest[:2], digest), nil
+}
+
+// ParseHexBinary decodes a binary value written as 0x followed by hex digits, as the Northwind export writes
+// pictures and photos
+func ParseHexBinary(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, "0x") {
+		return nil, fmt.Errorf("binary value does not start with 0x")
+	}
+	data, err := hex.DecodeString(value[2:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex digits: %v", err)
+	}
+	return data, nil
+}
+
+// SetBlobStore offloads binary attributes larger than threshold bytes into the blob store. The item keeps the key of
+// the blob in the attribute <name>Blob and reads fetch the blob transparently.
+func (r *Repository) SetBlobStore(blobStore BlobStore, threshold int) {
+	r.blobStore = blobStore
+	r.blobThreshold = threshold
+}
+
+// offloadBlobs replaces the large binary attributes of an item by pointers to the blob store
+func (r *Repository) offloadBlobs(item map[string]*dynamodb.AttributeValue) error {
+	if r.blobStore == nil {
+		return nil
+	}
+	for name, value := range item {
+		if value.B == nil || len(value.B) <= r.blobThreshold {
+			continue
+		}
+		key, err := r.blobStore.Put(value.B)
+		if err != nil {
+			return fmt.Errorf("could not offload attribute %v: %v", name, err)
+		}
+		delete(item, name)
+		item[name+blobAttributeSuffix] = &dynamodb.AttributeValue{S: aws.String(key)}
+	}
+	return nil
+}
+
+// fetchBlobs replaces the blob pointers of the items by the binary attributes
+func (r *Repository) fetchBlobs(items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	for _, item := range items {
+		for name, value := range item {
+			key := aws.StringValue(value.S)
+			if !strings.HasSuffix(name, blobAttributeSuffix) || !strings.HasPrefix(key, blobKeyPrefix) {
+				continue
+			}
+			if r.blobStore == nil {
+				return nil, fmt.Errorf("attribute %v is stored in a blob store, but none is configured", name)
+			}
+			data, err := r.blobStore.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			delete(item, name)
+			item[strings.TrimSuffix(name, blobAttributeSuffix)] = &dynamodb.AttributeValue{B: data}
+		}
+	}
+	return items, nil
+}
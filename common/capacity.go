@@ -0,0 +1,152 @@
+package common
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"sync"
+)
+
+// CapacityUsage holds consumed read and write capacity units of the table or an index
+type CapacityUsage struct {
+	Read  float64
+	Write float64
+}
+
+// ConsumedCapacity accumulates the capacity reported by DynamoDB for the requests of the repository, keyed by
+// index name and by an empty name for the base table
+type ConsumedCapacity struct {
+	mutex sync.Mutex
+	usage map[string]*CapacityUsage
+}
+
+func NewConsumedCapacity() *ConsumedCapacity {
+	return &ConsumedCapacity{
+		usage: map[string]*CapacityUsage{},
+	}
+}
+
+// Drain returns the capacity consumed since the last call
+func (c *ConsumedCapacity) Drain() map[string]CapacityUsage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	usage := map[string]CapacityUsage{}
+	for name, capacityUsage := range c.usage {
+		usage[name] = *capacityUsage
+	}
+	c.usage = map[string]*CapacityUsage{}
+	return usage
+}
+
+func (c *ConsumedCapacity) record(write bool, consumedCapacities ...*dynamodb.ConsumedCapacity) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, consumedCapacity := range consumedCapacities {
+		if consumedCapacity == nil {
+			continue
+		}
+		c.add("", write, consumedCapacity.Table)
+		for indexName, capacity := range consumedCapacity.GlobalSecondaryIndexes {
+			c.add(indexName, write, capacity)
+		}
+		for _, capacity := range consumedCapacity.LocalSecondaryIndexes {
+			// Local secondary indexes consume the capacity of the table
+			c.add("", write, capacity)
+		}
+	}
+}
+
+func (c *ConsumedCapacity) add(name string, write bool, capacity *dynamodb.Capacity) {
+	if capacity == nil {
+		return
+	}
+	usage, ok := c.usage[name]
+	if !ok {
+		usage = &CapacityUsage{}
+		c.usage[name] = usage
+	}
+	if write {
+		usage.Write += aws.Float64Value(capacity.CapacityUnits)
+	} else {
+		usage.Read += aws.Float64Value(capacity.CapacityUnits)
+	}
+}
+
+// capacityRecordingClient asks DynamoDB for the consumed capacity of every read and write of the repository
+type capacityRecordingClient struct {
+	dynamodbiface.DynamoDBAPI
+	consumedCapacity *ConsumedCapacity
+}
+
+func (c *capacityRecordingClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.Query(input)
+	if err == nil {
+		c.consumedCapacity.record(false, output.ConsumedCapacity)
+	}
+	return output, err
+}
+
+func (c *capacityRecordingClient) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	return c.DynamoDBAPI.QueryPages(input, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		c.consumedCapacity.record(false, output.ConsumedCapacity)
+		return fn(output, lastPage)
+	})
+}
+
+func (c *capacityRecordingClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.GetItem(input)
+	if err == nil {
+		c.consumedCapacity.record(false, output.ConsumedCapacity)
+	}
+	return output, err
+}
+
+func (c *capacityRecordingClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.BatchGetItem(input)
+	if err == nil {
+		c.consumedCapacity.record(false, output.ConsumedCapacity...)
+	}
+	return output, err
+}
+
+func (c *capacityRecordingClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.PutItem(input)
+	if err == nil {
+		c.consumedCapacity.record(true, output.ConsumedCapacity)
+	}
+	return output, err
+}
+
+func (c *capacityRecordingClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.DeleteItem(input)
+	if err == nil {
+		c.consumedCapacity.record(true, output.ConsumedCapacity)
+	}
+	return output, err
+}
+
+func (c *capacityRecordingClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.BatchWriteItem(input)
+	if err == nil {
+		c.consumedCapacity.record(true, output.ConsumedCapacity...)
+	}
+	return output, err
+}
+
+func (c *capacityRecordingClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityIndexes)
+	output, err := c.DynamoDBAPI.TransactWriteItems(input)
+	if err == nil {
+		c.consumedCapacity.record(true, output.ConsumedCapacity...)
+	}
+	return output, err
+}
@@ -0,0 +1,335 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type CopyOptions struct {
+	// Segments is the number of parallel scan segments of the source table
+	Segments int
+	// Transformation rewrites the items before they are written, nil copies them unchanged
+	Transformation *KeyTransformation
+	// WriteRate limits the items written per second to the target table, 0 for no limit
+	WriteRate float64
+	// SampleSize is the number of written items whose checksum is compared with the target table
+	SampleSize int
+}
+
+type CopyResult struct {
+	Scanned          int64
+	Skipped          int64
+	Written          int64
+	TargetCount      int64
+	Sampled          int64
+	SampleMismatches int64
+}
+
+// TableCopier copies all items of a table into another table, which may have a different key layout
+type TableCopier struct {
+	dynamoDBClient  dynamodbiface.DynamoDBAPI
+	sourceTableName string
+	targetTableName string
+}
+
+func NewTableCopier(dynamoDBClient dynamodbiface.DynamoDBAPI, sourceTableName string, targetTableName string) *TableCopier {
+	return &TableCopier{
+		dynamoDBClient:  dynamoDBClient,
+		sourceTableName: sourceTableName,
+		targetTableName: targetTableName,
+	}
+}
+
+// Copy scans the source table in parallel segments and writes the transformed items into the target table, which must
+// be empty. Finally it compares the number of written items with the number of items in the target table and the
+// checksums of a random sample of the written items with the items read back from the target table. The migration
+// lock is not copied, and neither is the migration history if the items are transformed, as it does not describe the
+// layout of the target table.
+func (c *TableCopier) Copy(options CopyOptions) (*CopyResult, error) {
+	if options.Segments < 1 {
+		options.Segments = 1
+	}
+	transformation := "none"
+	if options.Transformation != nil {
+		transformation = options.Transformation.Name
+	}
+
+	logger := log.WithFields(log.Fields{
+		"source":         c.sourceTableName,
+		"target":         c.targetTableName,
+		"transformation": transformation,
+		"segments":       options.Segments,
+	})
+	logger.Info("Copying the dynamoDB table")
+
+	targetKeys, err := c.targetKeyAttributes()
+	if err != nil {
+		return nil, err
+	}
+	err = c.checkTargetEmpty()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CopyResult{}
+	rateLimiter := NewRateLimiter(options.WriteRate)
+	samples := make([][]map[string]*dynamodb.AttributeValue, options.Segments)
+	errs := make([]error, options.Segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < options.Segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			sampleSize := (options.SampleSize + options.Segments - 1) / options.Segments
+			samples[segment], errs[segment] = c.copySegment(options, segment, rateLimiter, sampleSize, result)
+		}(segment)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return result, err
+		}
+	}
+
+	logger.WithFields(log.Fields{
+		"scanned": result.Scanned,
+		"skipped": result.Skipped,
+		"written": result.Written,
+	}).Info("Copied items, comparing the tables")
+
+	result.TargetCount, err = c.countTargetItems(options.Segments)
+	if err != nil {
+		return result, err
+	}
+
+	for _, segmentSamples := range samples {
+		for start := 0; start < len(segmentSamples); start += batchGetItemLimit {
+			end := start + batchGetItemLimit
+			if end > len(segmentSamples) {
+				end = len(segmentSamples)
+			}
+			err = c.compareSamples(segmentSamples[start:end], targetKeys, result)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	logger = logger.WithFields(log.Fields{
+		"written":           result.Written,
+		"target_count":      result.TargetCount,
+		"sampled":           result.Sampled,
+		"sample_mismatches": result.SampleMismatches,
+	})
+	if result.TargetCount != result.Written {
+		return result, fmt.Errorf("target table %v contains %v items, but %v items were written", c.targetTableName, result.TargetCount, result.Written)
+	}
+	if result.SampleMismatches > 0 {
+		return result, fmt.Errorf("%v of %v sampled items differ in target table %v", result.SampleMismatches, result.Sampled, c.targetTableName)
+	}
+
+	logger.Info("Copied table")
+
+	return result, nil
+}
+
+// copySegment copies one scan segment and returns a random sample of the written items
+func (c *TableCopier) copySegment(options CopyOptions, segment int, rateLimiter *RateLimiter, sampleSize int, result *CopyResult) ([]map[string]*dynamodb.AttributeValue, error) {
+	batchWriter := NewBatchWriter(c.dynamoDBClient, c.targetTableName)
+	batchWriter.SetRateLimiter(rateLimiter)
+
+	var sample []map[string]*dynamodb.AttributeValue
+	var seen int
+	var copyErr error
+	err := c.dynamoDBClient.ScanPages(&dynamodb.ScanInput{
+		TableName:     aws.String(c.sourceTableName),
+		Segment:       aws.Int64(int64(segment)),
+		TotalSegments: aws.Int64(int64(options.Segments)),
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		atomic.AddInt64(&result.Scanned, int64(len(output.Items)))
+		for _, item := range output.Items {
+			if skipMetaItem(item, options.Transformation) {
+				atomic.AddInt64(&result.Skipped, 1)
+				continue
+			}
+			if options.Transformation != nil {
+				item, copyErr = options.Transformation.Transform(item)
+				if copyErr != nil {
+					return false
+				}
+				if item == nil {
+					atomic.AddInt64(&result.Skipped, 1)
+					continue
+				}
+			}
+
+			copyErr = batchWriter.Put(item)
+			if copyErr != nil {
+				return false
+			}
+
+			// Reservoir sampling keeps every written item in the sample with the same probability
+			seen++
+			if len(sample) < sampleSize {
+				sample = append(sample, item)
+			} else if i := rand.Intn(seen); i < sampleSize {
+				sample[i] = item
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning dynamoDB table %v: %v", c.sourceTableName, err)
+	}
+	if copyErr == nil {
+		copyErr = batchWriter.Flush()
+	}
+	atomic.AddInt64(&result.Written, batchWriter.Written())
+	if copyErr != nil {
+		return nil, fmt.Errorf("error copying segment %v of dynamoDB table %v: %v", segment, c.sourceTableName, copyErr)
+	}
+
+	return sample, nil
+}
+
+// skipMetaItem reports whether a metadata item is left out of the copy
+func skipMetaItem(item map[string]*dynamodb.AttributeValue, transformation *KeyTransformation) bool {
+	if !strings.HasPrefix(aws.StringValue(item["pk"].S), metaPrefix+"#") {
+		return false
+	}
+	return transformation != nil || aws.StringValue(item["sk"].S) == migrationsLockSk
+}
+
+func (c *TableCopier) compareSamples(sample []map[string]*dynamodb.AttributeValue, keyAttributes []string, result *CopyResult) error {
+	var keys []map[string]*dynamodb.AttributeValue
+	checksums := map[string]string{}
+	for _, item := range sample {
+		key := map[string]*dynamodb.AttributeValue{}
+		for _, attribute := range keyAttributes {
+			key[attribute] = item[attribute]
+		}
+		keys = append(keys, key)
+
+		checksum, err := itemChecksum(item)
+		if err != nil {
+			return err
+		}
+		checksums[formatItemKey(item, keyAttributes)] = checksum
+	}
+
+	err := batchGetItems(c.dynamoDBClient, c.targetTableName, keys, func(item map[string]*dynamodb.AttributeValue) {
+		key := formatItemKey(item, keyAttributes)
+		checksum, err := itemChecksum(item)
+		if err == nil && checksum == checksums[key] {
+			delete(checksums, key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Items left over are missing in the target table or differ
+	result.Sampled += int64(len(sample))
+	result.SampleMismatches += int64(len(checksums))
+	for key := range checksums {
+		log.WithFields(log.Fields{
+			"target": c.targetTableName,
+			"key":    key,
+		}).Warn("Sampled item differs in the target table")
+	}
+	return nil
+}
+
+// checkTargetEmpty fails if the target table holds items, their count would not match the written items
+func (c *TableCopier) checkTargetEmpty() error {
+	output, err := c.dynamoDBClient.Scan(&dynamodb.ScanInput{
+		TableName: aws.String(c.targetTableName),
+		Select:    aws.String(dynamodb.SelectCount),
+		Limit:     aws.Int64(1),
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning dynamoDB table %v: %v", c.targetTableName, err)
+	}
+	if aws.Int64Value(output.Count) > 0 {
+		return fmt.Errorf("target table %v is not empty", c.targetTableName)
+	}
+	return nil
+}
+
+func (c *TableCopier) countTargetItems(segments int) (int64, error) {
+	var count int64
+	errs := make([]error, segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			errs[segment] = c.dynamoDBClient.ScanPages(&dynamodb.ScanInput{
+				TableName:      aws.String(c.targetTableName),
+				Select:         aws.String(dynamodb.SelectCount),
+				ConsistentRead: aws.Bool(true),
+				Segment:        aws.Int64(int64(segment)),
+				TotalSegments:  aws.Int64(int64(segments)),
+			}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+				atomic.AddInt64(&count, aws.Int64Value(output.Count))
+				return true
+			})
+		}(segment)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return 0, fmt.Errorf("error counting items of dynamoDB table %v: %v", c.targetTableName, err)
+		}
+	}
+	return count, nil
+}
+
+func (c *TableCopier) targetKeyAttributes() ([]string, error) {
+	output, err := c.dynamoDBClient.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(c.targetTableName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe dynamoDB table %v: %v", c.targetTableName, err)
+	}
+
+	var attributes []string
+	for _, element := range output.Table.KeySchema {
+		attributes = append(attributes, aws.StringValue(element.AttributeName))
+	}
+	return attributes, nil
+}
+
+// itemChecksum returns the SHA-256 of the DynamoDB JSON of an item, which is independent of the attribute order
+func itemChecksum(item map[string]*dynamodb.AttributeValue) (string, error) {
+	data, err := MarshalDynamoDBJSON(item)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling item: %v", err)
+	}
+	checksum := sha256.Sum256(data)
+	return hex.EncodeToString(checksum[:]), nil
+}
+
+func formatItemKey(item map[string]*dynamodb.AttributeValue, keyAttributes []string) string {
+	var key string
+	for i, attribute := range keyAttributes {
+		if i > 0 {
+			key += "|"
+		}
+		if value := item[attribute]; value != nil {
+			key += aws.StringValue(value.S) + aws.StringValue(value.N)
+		}
+	}
+	return key
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"strings"
+)
+
+const (
+	// decimalScale is the number of fractional digits of a Decimal, as of the money type of the Northwind database
+	decimalScale       = 4
+	decimalUnit  int64 = 10000
+)
+
+// Decimal is a fixed-point number with four fractional digits, used for money and rates. It is stored as a
+// DynamoDB number. The zero Decimal is a missing value.
+type Decimal struct {
+	units int64
+	valid bool
+}
+
+func NewDecimal(value int64) Decimal {
+	return Decimal{units: value * decimalUnit, valid: true}
+}
+
+// ParseDecimal parses a decimal number with up to four fractional digits, e.g. 14.00 or -0.15
+func ParseDecimal(value string) (Decimal, error) {
+	s := strings.TrimSpace(value)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	integer, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	if integer == "" && fraction == "" {
+		return Decimal{}, fmt.Errorf("%q is not a decimal number", value)
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > decimalScale {
+		return Decimal{}, fmt.Errorf("%q has more than %v fractional digits", value, decimalScale)
+	}
+	digits := integer + fraction + strings.Repeat("0", decimalScale-len(fraction))
+	for _, digit := range digits {
+		if digit < '0' || digit > '9' {
+			return Decimal{}, fmt.Errorf("%q is not a decimal number", value)
+		}
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("%q is out of range", value)
+	}
+	if negative {
+		units = -units
+	}
+	return Decimal{units: units, valid: true}, nil
+}
+
+// Sub, Mul and MulInt treat missing values as zero
+func (d Decimal) Sub(other Decimal) Decimal {
+	return Decimal{units: d.units - other.units, valid: true}
+}
+
+// Mul returns the product rounded half away from zero to four fractional digits
+func (d Decimal) Mul(other Decimal) Decimal {
+	product := d.units * other.units
+	half := decimalUnit / 2
+	if product < 0 {
+		half = -half
+	}
+	return Decimal{units: (product + half) / decimalUnit, valid: true}
+}
+
+func (d Decimal) MulInt(factor int) Decimal {
+	return Decimal{units: d.units * int64(factor), valid: true}
+}
+
+func (d Decimal) IsZero() bool {
+	return d.units == 0
+}
+
+func (d Decimal) IsMissing() bool {
+	return !d.valid
+}
+
+// StringFixed formats the decimal rounded half away from zero to the given number of fractional digits
+func (d Decimal) StringFixed(digits int) string {
+	if digits < 0 || digits > decimalScale {
+		digits = decimalScale
+	}
+	units := d.units
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	divisor := int64(1)
+	for i := digits; i < decimalScale; i++ {
+		divisor *= 10
+	}
+	units = (units + divisor/2) / divisor
+
+	scale := decimalUnit / divisor
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, units)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, units/scale, digits, units%scale)
+}
+
+// String formats the decimal without trailing zeros, e.g. 14 or 0.15, and a missing value as an empty string
+func (d Decimal) String() string {
+	if d.IsMissing() {
+		return ""
+	}
+	s := d.StringFixed(decimalScale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func (d Decimal) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if d.IsMissing() {
+		av.NULL = aws.Bool(true)
+		return nil
+	}
+	av.N = aws.String(d.String())
+	return nil
+}
+
+func (d *Decimal) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if av.NULL != nil {
+		*d = Decimal{}
+		return nil
+	}
+	if av.N == nil {
+		return fmt.Errorf("decimal must be a number attribute")
+	}
+	parsed, err := ParseDecimal(*av.N)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
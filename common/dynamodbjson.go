@@ -0,0 +1,193 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// MarshalDynamoDBJSON encodes an item in DynamoDB JSON, the format of the DynamoDB export to S3:
+// {"Item":{"pk":{"S":"employees#2"},...}}
+func MarshalDynamoDBJSON(item map[string]*dynamodb.AttributeValue) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"Item": dynamoDBJSONMap(item),
+	})
+}
+
+func UnmarshalDynamoDBJSON(data []byte) (map[string]*dynamodb.AttributeValue, error) {
+	var line struct {
+		Item map[string]json.RawMessage
+	}
+	err := json.Unmarshal(data, &line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DynamoDB JSON: %v", err)
+	}
+	if line.Item == nil {
+		return nil, fmt.Errorf("invalid DynamoDB JSON: no Item")
+	}
+
+	item := map[string]*dynamodb.AttributeValue{}
+	for name, raw := range line.Item {
+		value, err := attributeValueFromDynamoDBJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DynamoDB JSON in attribute %v: %v", name, err)
+		}
+		item[name] = value
+	}
+	return item, nil
+}
+
+// MarshalPlainJSON encodes an item as plain JSON. Binary attributes become base64 strings and sets become lists,
+// so plain JSON does not round trip these types.
+func MarshalPlainJSON(item map[string]*dynamodb.AttributeValue) ([]byte, error) {
+	var value map[string]interface{}
+	err := dynamodbattribute.UnmarshalMap(item, &value)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
+	}
+	return json.Marshal(value)
+}
+
+func UnmarshalPlainJSON(data []byte) (map[string]*dynamodb.AttributeValue, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	attributeValues, err := dynamodbattribute.MarshalMap(plainJSONNumbers(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+	}
+	return attributeValues, nil
+}
+
+// plainJSONNumbers converts JSON numbers so that they are marshalled as DynamoDB numbers instead of strings
+func plainJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return dynamodbattribute.Number(v.String())
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = plainJSONNumbers(element)
+		}
+		return v
+	case []interface{}:
+		for i, element := range v {
+			v[i] = plainJSONNumbers(element)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func dynamoDBJSONMap(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	values := map[string]interface{}{}
+	for name, value := range item {
+		values[name] = dynamoDBJSON(value)
+	}
+	return values
+}
+
+func dynamoDBJSON(value *dynamodb.AttributeValue) map[string]interface{} {
+	switch {
+	case value.S != nil:
+		return map[string]interface{}{"S": *value.S}
+	case value.N != nil:
+		return map[string]interface{}{"N": *value.N}
+	case value.B != nil:
+		return map[string]interface{}{"B": base64.StdEncoding.EncodeToString(value.B)}
+	case value.BOOL != nil:
+		return map[string]interface{}{"BOOL": *value.BOOL}
+	case value.NULL != nil:
+		return map[string]interface{}{"NULL": *value.NULL}
+	case value.M != nil:
+		return map[string]interface{}{"M": dynamoDBJSONMap(value.M)}
+	case value.L != nil:
+		list := []interface{}{}
+		for _, element := range value.L {
+			list = append(list, dynamoDBJSON(element))
+		}
+		return map[string]interface{}{"L": list}
+	case value.SS != nil:
+		return map[string]interface{}{"SS": aws.StringValueSlice(value.SS)}
+	case value.NS != nil:
+		return map[string]interface{}{"NS": aws.StringValueSlice(value.NS)}
+	case value.BS != nil:
+		var set []string
+		for _, element := range value.BS {
+			set = append(set, base64.StdEncoding.EncodeToString(element))
+		}
+		return map[string]interface{}{"BS": set}
+	default:
+		return map[string]interface{}{"NULL": true}
+	}
+}
+
+func attributeValueFromDynamoDBJSON(raw json.RawMessage) (*dynamodb.AttributeValue, error) {
+	var typed map[string]json.RawMessage
+	err := json.Unmarshal(raw, &typed)
+	if err != nil {
+		return nil, err
+	}
+	if len(typed) != 1 {
+		return nil, fmt.Errorf("expected exactly one type, got %v", len(typed))
+	}
+
+	value := &dynamodb.AttributeValue{}
+	for attributeType, data := range typed {
+		switch attributeType {
+		case "S":
+			err = json.Unmarshal(data, &value.S)
+		case "N":
+			err = json.Unmarshal(data, &value.N)
+		case "B":
+			err = json.Unmarshal(data, &value.B)
+		case "BOOL":
+			err = json.Unmarshal(data, &value.BOOL)
+		case "NULL":
+			err = json.Unmarshal(data, &value.NULL)
+		case "SS":
+			err = json.Unmarshal(data, &value.SS)
+		case "NS":
+			err = json.Unmarshal(data, &value.NS)
+		case "BS":
+			err = json.Unmarshal(data, &value.BS)
+		case "M":
+			var elements map[string]json.RawMessage
+			err = json.Unmarshal(data, &elements)
+			value.M = map[string]*dynamodb.AttributeValue{}
+			for name, element := range elements {
+				if err != nil {
+					break
+				}
+				value.M[name], err = attributeValueFromDynamoDBJSON(element)
+			}
+		case "L":
+			var elements []json.RawMessage
+			err = json.Unmarshal(data, &elements)
+			value.L = []*dynamodb.AttributeValue{}
+			for _, element := range elements {
+				if err != nil {
+					break
+				}
+				var elementValue *dynamodb.AttributeValue
+				elementValue, err = attributeValueFromDynamoDBJSON(element)
+				value.L = append(value.L, elementValue)
+			}
+		default:
+			return nil, fmt.Errorf("unknown type %v", attributeType)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
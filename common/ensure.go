@@ -0,0 +1,312 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// EnsureOptions control the changes EnsureTable may apply
+type EnsureOptions struct {
+	// RecreateIndexes names the global secondary indexes which may be removed and added again when their key schema
+	// or projection differs from the schema. The index cannot be queried until it is backfilled again.
+	RecreateIndexes []string
+}
+
+// EnsureTable creates the table if it does not exist and otherwise converges it to the schema with the minimal set of
+// UpdateTable calls, one global secondary index change at a time. It returns the applied changes. Differences it
+// does not change, like global secondary indexes which are not in the schema, are returned as an error after the
+// other changes were applied.
+func (r *TableManager) EnsureTable(options EnsureOptions) ([]string, error) {
+	logger := log.WithField("table", r.tableName)
+
+	_, err := r.dynamoDBClient.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(r.tableName),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		err = r.CreateTable()
+		if err != nil {
+			return nil, err
+		}
+		return []string{"created table"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not describe dynamoDB table %v: %v", r.tableName, err)
+	}
+
+	logger.Info("Waiting for the dynamoDB table to be active")
+	err = r.WaitUntilActive()
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := r.schema.CreateTableInput(r.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema for dynamoDB table %v: %v", r.tableName, err)
+	}
+	table, err := r.describeTable()
+	if err != nil {
+		return nil, err
+	}
+	err = checkConvergeable(expected, table)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes, drifts []string
+	for _, step := range []func(*dynamodb.CreateTableInput, EnsureOptions) ([]string, []string, error){
+		r.ensureCapacity,
+		r.ensureGlobalSecondaryIndexes,
+		r.ensureStream,
+		r.ensureTTL,
+	} {
+		stepChanges, stepDrifts, err := step(expected, options)
+		changes = append(changes, stepChanges...)
+		drifts = append(drifts, stepDrifts...)
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	for _, change := range changes {
+		logger.WithField("change", change).Info("Changed table")
+	}
+	for _, drift := range drifts {
+		logger.WithField("drift", drift).Warn("Table differs from the table schema")
+	}
+	if len(drifts) > 0 {
+		return changes, fmt.Errorf("dynamoDB table %v still differs from the table schema: %v", r.tableName, strings.Join(drifts, ", "))
+	}
+	if len(changes) == 0 {
+		logger.Info("Table already matches the table schema")
+	}
+
+	return changes, nil
+}
+
+// checkConvergeable rejects differences which UpdateTable cannot change, they require a new table
+func checkConvergeable(expected *dynamodb.CreateTableInput, table *dynamodb.TableDescription) error {
+	var problems []string
+	if formatKeySchema(expected.KeySchema) != formatKeySchema(table.KeySchema) {
+		problems = append(problems, fmt.Sprintf("key schema %v differs from %v", formatKeySchema(table.KeySchema), formatKeySchema(expected.KeySchema)))
+	}
+
+	expectedLocalIndexes := map[string]string{}
+	for _, index := range expected.LocalSecondaryIndexes {
+		expectedLocalIndexes[aws.StringValue(index.IndexName)] = formatKeySchema(index.KeySchema) + " " + formatProjection(index.Projection)
+	}
+	actualLocalIndexes := map[string]string{}
+	for _, index := range table.LocalSecondaryIndexes {
+		actualLocalIndexes[aws.StringValue(index.IndexName)] = formatKeySchema(index.KeySchema) + " " + formatProjection(index.Projection)
+	}
+	for name, index := range expectedLocalIndexes {
+		if actualLocalIndexes[name] != index {
+			problems = append(problems, fmt.Sprintf("local index %v differs", name))
+		}
+	}
+	for name := range actualLocalIndexes {
+		if _, ok := expectedLocalIndexes[name]; !ok {
+			problems = append(problems, fmt.Sprintf("local index %v is not in the table schema", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("dynamoDB table %v cannot be updated to the table schema: %v", aws.StringValue(table.TableName), strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// ensureCapacity converges billing mode and throughput, but only if the schema sets a billing mode
+func (r *TableManager) ensureCapacity(expected *dynamodb.CreateTableInput, options EnsureOptions) ([]string, []string, error) {
+	if r.schema.BillingMode == "" {
+		return nil, nil, nil
+	}
+
+	table, err := r.describeTable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	billingMode := dynamodb.BillingModeProvisioned
+	if table.BillingModeSummary != nil {
+		billingMode = aws.StringValue(table.BillingModeSummary.BillingMode)
+	}
+	var changes []string
+	if billingMode != r.schema.BillingMode {
+		changes = append(changes, fmt.Sprintf("changed billing mode from %v to %v", billingMode, r.schema.BillingMode))
+	}
+	if expected.ProvisionedThroughput != nil {
+		if actual, desired := formatThroughputDescription(table.ProvisionedThroughput), formatThroughput(expected.ProvisionedThroughput); actual != desired {
+			changes = append(changes, fmt.Sprintf("changed throughput from %v to %v", actual, desired))
+		}
+		for _, index := range table.GlobalSecondaryIndexes {
+			indexSchema := r.schema.Index(aws.StringValue(index.IndexName))
+			if indexSchema == nil {
+				continue
+			}
+			if actual, desired := formatThroughputDescription(index.ProvisionedThroughput), formatThroughput(r.schema.IndexThroughput(indexSchema)); actual != desired {
+				changes = append(changes, fmt.Sprintf("changed throughput of index %v from %v to %v", indexSchema.Name, actual, desired))
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil, nil
+	}
+
+	err = r.SetCapacity()
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, nil, nil
+}
+
+// ensureGlobalSecondaryIndexes adds missing indexes. Indexes whose keys or projection differ are only recreated when
+// the options name them, as they cannot be queried until they are backfilled again, and indexes which are not in the
+// schema are never removed. Both are returned as drifts otherwise.
+func (r *TableManager) ensureGlobalSecondaryIndexes(expected *dynamodb.CreateTableInput, options EnsureOptions) ([]string, []string, error) {
+	table, err := r.describeTable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expectedIndexes := map[string]*dynamodb.GlobalSecondaryIndex{}
+	for _, index := range expected.GlobalSecondaryIndexes {
+		expectedIndexes[aws.StringValue(index.IndexName)] = index
+	}
+	recreate := map[string]bool{}
+	for _, name := range options.RecreateIndexes {
+		if _, ok := expectedIndexes[name]; !ok {
+			return nil, nil, fmt.Errorf("global secondary index %v is not defined in the table schema", name)
+		}
+		recreate[name] = true
+	}
+
+	var changes, drifts []string
+	existing := map[string]bool{}
+	for _, index := range table.GlobalSecondaryIndexes {
+		name := aws.StringValue(index.IndexName)
+		expectedIndex, ok := expectedIndexes[name]
+		existing[name] = true
+		switch {
+		case !ok:
+			drifts = append(drifts, fmt.Sprintf("index %v is not in the table schema", name))
+		case formatKeySchema(expectedIndex.KeySchema) == formatKeySchema(index.KeySchema) &&
+			formatProjection(expectedIndex.Projection) == formatProjection(index.Projection):
+			// Index matches the schema
+		case !recreate[name]:
+			drifts = append(drifts, fmt.Sprintf("index %v has key schema %v and projection %v instead of %v and %v",
+				name, formatKeySchema(index.KeySchema), formatProjection(index.Projection),
+				formatKeySchema(expectedIndex.KeySchema), formatProjection(expectedIndex.Projection)))
+		default:
+			// Keys and projection of an index cannot be updated
+			log.WithFields(log.Fields{
+				"table": r.tableName,
+				"index": name,
+			}).Warn("Recreating index, it cannot be queried until it is backfilled")
+			err = r.RemoveIndex(name)
+			if err != nil {
+				return changes, drifts, err
+			}
+			err = r.AddIndex(name)
+			if err != nil {
+				return changes, drifts, err
+			}
+			changes = append(changes, fmt.Sprintf("recreated index %v", name))
+		}
+	}
+
+	for _, index := range expected.GlobalSecondaryIndexes {
+		name := aws.StringValue(index.IndexName)
+		if existing[name] {
+			continue
+		}
+		err = r.AddIndex(name)
+		if err != nil {
+			return changes, drifts, err
+		}
+		changes = append(changes, fmt.Sprintf("added index %v", name))
+	}
+
+	return changes, drifts, nil
+}
+
+// ensureStream enables, disables or changes the view type of the stream. A view type can only be changed by
+// disabling the stream first.
+func (r *TableManager) ensureStream(expected *dynamodb.CreateTableInput, options EnsureOptions) ([]string, []string, error) {
+	table, err := r.describeTable()
+	if err != nil {
+		return nil, nil, err
+	}
+	actual, desired := formatStream(table.StreamSpecification), formatStream(expected.StreamSpecification)
+	if actual == desired {
+		return nil, nil, nil
+	}
+
+	if table.StreamSpecification != nil && aws.BoolValue(table.StreamSpecification.StreamEnabled) {
+		err = r.UpdateTable(&dynamodb.UpdateTableInput{
+			TableName: aws.String(r.tableName),
+			StreamSpecification: &dynamodb.StreamSpecification{
+				StreamEnabled: aws.Bool(false),
+			},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if expected.StreamSpecification != nil {
+		err = r.UpdateTable(&dynamodb.UpdateTableInput{
+			TableName:           aws.String(r.tableName),
+			StreamSpecification: expected.StreamSpecification,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return []string{fmt.Sprintf("changed stream from %v to %v", actual, desired)}, nil, nil
+}
+
+// ensureTTL enables or disables the time to live. A time to live which is being enabled or disabled counts as
+// enabled or disabled. DynamoDB allows one change of the time to live per hour, so changing the attribute of an
+// enabled time to live, which requires disabling it first, is returned as a drift.
+func (r *TableManager) ensureTTL(expected *dynamodb.CreateTableInput, options EnsureOptions) ([]string, []string, error) {
+	ttl, err := r.DescribeTTL()
+	if err != nil {
+		return nil, nil, err
+	}
+	actual, desired := formatTTL(ttl), dynamodb.TimeToLiveStatusDisabled
+	if r.schema.TimeToLiveAttribute != "" {
+		desired = fmt.Sprintf("%v on %v", dynamodb.TimeToLiveStatusEnabled, r.schema.TimeToLiveAttribute)
+	}
+	if actual == desired {
+		return nil, nil, nil
+	}
+
+	switch {
+	case ttl != nil && aws.StringValue(ttl.TimeToLiveStatus) == dynamodb.TimeToLiveStatusDisabling:
+		return nil, []string{fmt.Sprintf("time to live is being disabled and can only be changed to %v afterwards", desired)}, nil
+	case actual != dynamodb.TimeToLiveStatusDisabled && desired != dynamodb.TimeToLiveStatusDisabled:
+		return nil, []string{fmt.Sprintf("time to live is %v instead of %v and can only be changed by disabling it first", actual, desired)}, nil
+	case desired == dynamodb.TimeToLiveStatusDisabled:
+		_, err = r.dynamoDBClient.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+			TableName: aws.String(r.tableName),
+			TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+				AttributeName: ttl.AttributeName,
+				Enabled:       aws.Bool(false),
+			},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not disable time to live of dynamoDB table %v: %v", r.tableName, err)
+		}
+	default:
+		err = r.EnableTTL()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return []string{fmt.Sprintf("changed time to live from %v to %v", actual, desired)}, nil, nil
+}
@@ -0,0 +1,343 @@
+package common
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ExportFormatDynamoDBJSON = "dynamodb-json"
+	ExportFormatJSONL        = "jsonl"
+
+	exportManifestFile = "manifest.json"
+	exportDataDir      = "data"
+	exportCompression  = "gzip"
+	// Items are at most 400 KB, base64 encoded binary attributes take a third more
+	exportMaxLineSize = 1024 * 1024
+)
+
+// ExportManifest describes an export: which table it was taken from and which files it consists of
+type ExportManifest struct {
+	Table       string       `json:"table"`
+	Format      string       `json:"format"`
+	Compression string       `json:"compression,omitempty"`
+	ExportTime  time.Time    `json:"exportTime"`
+	ItemCount   int64        `json:"itemCount"`
+	Files       []ExportFile `json:"files"`
+}
+
+// ExportFile is a data file of an export. The checksum is the SHA-256 of the file as stored, i.e. compressed.
+type ExportFile struct {
+	Name      string `json:"name"`
+	ItemCount int64  `json:"itemCount"`
+	SHA256    string `json:"sha256"`
+}
+
+// Exporter writes all items of a table to local files and reads them back into a table
+type Exporter struct {
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+	tableName      string
+}
+
+func NewExporter(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string) *Exporter {
+	return &Exporter{
+		dynamoDBClient: dynamoDBClient,
+		tableName:      tableName,
+	}
+}
+
+// Export scans the table with the given number of parallel segments and writes one data file per segment plus a
+// manifest into the directory
+func (e *Exporter) Export(directory string, format string, compress bool, segments int) (*ExportManifest, error) {
+	if format != ExportFormatDynamoDBJSON && format != ExportFormatJSONL {
+		return nil, fmt.Errorf("unknown export format %v", format)
+	}
+	if segments < 1 {
+		return nil, fmt.Errorf("invalid number of segments %v", segments)
+	}
+
+	log.WithFields(log.Fields{
+		"table":     e.tableName,
+		"directory": directory,
+		"format":    format,
+		"segments":  segments,
+	}).Info("Exporting the dynamoDB table")
+
+	err := os.MkdirAll(filepath.Join(directory, exportDataDir), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create export directory %v: %v", directory, err)
+	}
+
+	manifest := &ExportManifest{
+		Table:      e.tableName,
+		Format:     format,
+		ExportTime: time.Now().UTC(),
+		Files:      make([]ExportFile, segments),
+	}
+	if compress {
+		manifest.Compression = exportCompression
+	}
+
+	errs := make([]error, segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			name := fmt.Sprintf("%s/segment-%04d.json", exportDataDir, segment)
+			if compress {
+				name += ".gz"
+			}
+			file, err := e.exportSegment(filepath.Join(directory, name), format, compress, segment, segments)
+			if err != nil {
+				errs[segment] = err
+				return
+			}
+			file.Name = name
+			manifest.Files[segment] = *file
+		}(segment)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range manifest.Files {
+		manifest.ItemCount += file.ItemCount
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling export manifest: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(directory, exportManifestFile), data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not write export manifest: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"table": e.tableName,
+		"items": manifest.ItemCount,
+	}).Info("Exported table")
+
+	return manifest, nil
+}
+
+func (e *Exporter) exportSegment(path string, format string, compress bool, segment int, segments int) (*ExportFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create export file %v: %v", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	var writer io.Writer = io.MultiWriter(file, hash)
+	var gzipWriter *gzip.Writer
+	if compress {
+		gzipWriter = gzip.NewWriter(writer)
+		writer = gzipWriter
+	}
+	bufferedWriter := bufio.NewWriter(writer)
+
+	var itemCount int64
+	var writeErr error
+	err = e.dynamoDBClient.ScanPages(&dynamodb.ScanInput{
+		TableName:     aws.String(e.tableName),
+		Segment:       aws.Int64(int64(segment)),
+		TotalSegments: aws.Int64(int64(segments)),
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var line []byte
+			if format == ExportFormatJSONL {
+				line, writeErr = MarshalPlainJSON(item)
+			} else {
+				line, writeErr = MarshalDynamoDBJSON(item)
+			}
+			if writeErr != nil {
+				return false
+			}
+			_, writeErr = bufferedWriter.Write(append(line, '\n'))
+			if writeErr != nil {
+				return false
+			}
+			itemCount++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning dynamoDB table %v: %v", e.tableName, err)
+	}
+	if writeErr != nil {
+		return nil, fmt.Errorf("could not write export file %v: %v", path, writeErr)
+	}
+
+	err = bufferedWriter.Flush()
+	if err == nil && gzipWriter != nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not write export file %v: %v", path, err)
+	}
+
+	log.WithFields(log.Fields{
+		"table":   e.tableName,
+		"segment": segment,
+		"items":   itemCount,
+	}).Info("Exported segment")
+
+	return &ExportFile{
+		ItemCount: itemCount,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Import verifies the checksums of an export and writes its items into the table, importing the given number of
+// files in parallel. It returns the number of imported items.
+func (e *Exporter) Import(directory string, workers int) (int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(directory, exportManifestFile))
+	if err != nil {
+		return 0, fmt.Errorf("could not read export manifest: %v", err)
+	}
+	var manifest ExportManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid export manifest: %v", err)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	log.WithFields(log.Fields{
+		"table":     e.tableName,
+		"directory": directory,
+		"source":    manifest.Table,
+		"items":     manifest.ItemCount,
+	}).Info("Importing into the dynamoDB table")
+
+	for _, file := range manifest.Files {
+		err := verifyExportFile(directory, file)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var imported int64
+	files := make(chan ExportFile)
+	errs := make(chan error, len(manifest.Files))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				count, err := e.importFile(directory, &manifest, file)
+				atomic.AddInt64(&imported, count)
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, file := range manifest.Files {
+		files <- file
+	}
+	close(files)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		return imported, err
+	}
+	if imported != manifest.ItemCount {
+		return imported, fmt.Errorf("imported %v items, but the manifest lists %v", imported, manifest.ItemCount)
+	}
+
+	log.WithFields(log.Fields{
+		"table": e.tableName,
+		"items": imported,
+	}).Info("Imported table")
+
+	return imported, nil
+}
+
+func (e *Exporter) importFile(directory string, manifest *ExportManifest, exportFile ExportFile) (int64, error) {
+	path := filepath.Join(directory, exportFile.Name)
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not open export file %v: %v", path, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if manifest.Compression == exportCompression {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, fmt.Errorf("could not read export file %v: %v", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), exportMaxLineSize)
+
+	batchWriter := NewBatchWriter(e.dynamoDBClient, e.tableName)
+	for line := 1; scanner.Scan(); line++ {
+		var item map[string]*dynamodb.AttributeValue
+		if manifest.Format == ExportFormatJSONL {
+			item, err = UnmarshalPlainJSON(scanner.Bytes())
+		} else {
+			item, err = UnmarshalDynamoDBJSON(scanner.Bytes())
+		}
+		if err != nil {
+			return batchWriter.Written(), fmt.Errorf("%v:%v: %v", path, line, err)
+		}
+
+		err = batchWriter.Put(item)
+		if err != nil {
+			return batchWriter.Written(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return batchWriter.Written(), fmt.Errorf("could not read export file %v: %v", path, err)
+	}
+
+	err = batchWriter.Flush()
+	return batchWriter.Written(), err
+}
+
+func verifyExportFile(directory string, exportFile ExportFile) error {
+	path := filepath.Join(directory, exportFile.Name)
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open export file %v: %v", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("could not read export file %v: %v", path, err)
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != exportFile.SHA256 {
+		return fmt.Errorf("checksum mismatch of export file %v: expected %v, got %v", path, exportFile.SHA256, checksum)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacyDateLayout is the date format of the CSV files, which was stored as is before dates were typed
+const legacyDateLayout = "2006-01-02 15:04:05.000"
+
+var (
+	decimalAttributes = []string{"unitPrice", "discount", "freight"}
+	integerAttributes = []string{"unitsInStock", "unitsOnOrder", "reorderLevel", "quantity"}
+	dateAttributes    = []string{"birthDate", "hireDate", "orderDate", "requiredDate", "shippedDate"}
+)
+
+// Migrations returns all migrations of the table layout
+func Migrations() []*Migration {
+	return []*Migration{
+		{
+			ID:          "0001_fix_customer_pk",
+			Description: "Rewrite customer keys written as customers#%!d(string=ID) to customers#ID",
+			Up: func(ctx *MigrationContext) error {
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					pk := aws.StringValue(item["pk"].S)
+					fixedPk, ok := fixedCustomerPk(pk)
+					if !ok {
+						return nil
+					}
+
+					if aws.StringValue(item["data"].S) == pk {
+						item["data"] = &dynamodb.AttributeValue{S: aws.String(fixedPk)}
+					}
+					return ctx.RewriteKey(item, fixedPk, aws.StringValue(item["sk"].S))
+				})
+			},
+		},
+		{
+			ID:          "0002_prefix_customer_and_shipper_sk",
+			Description: "Prefix the contact name of customers and the company name of shippers in sk with their entity type",
+			Up: func(ctx *MigrationContext) error {
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					pk, sk := aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S)
+					if prefixedSk, ok := prefixedSortKey(pk, sk); ok {
+						return ctx.RewriteKey(item, pk, prefixedSk)
+					}
+					return nil
+				})
+			},
+			Down: func(ctx *MigrationContext) error {
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					pk, sk := aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S)
+					if unprefixedSk, ok := unprefixedSortKey(pk, sk); ok {
+						return ctx.RewriteKey(item, pk, unprefixedSk)
+					}
+					return nil
+				})
+			},
+		},
+		{
+			ID:          "0003_add_gsi_2",
+			Description: "Add the inverted index gsi_2 on data/sk",
+			Up: func(ctx *MigrationContext) error {
+				return ctx.TableManager.AddIndex("gsi_2")
+			},
+			Down: func(ctx *MigrationContext) error {
+				return ctx.TableManager.RemoveIndex("gsi_2")
+			},
+		},
+		{
+			ID:          "0004_remove_null_tokens",
+			Description: "Remove attributes holding the literal NULL and replace NULL key parts with " + MissingKeyPart,
+			Up: func(ctx *MigrationContext) error {
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					migrated, changed := withoutNullTokens(item)
+					if !changed {
+						return nil
+					}
+					pk, sk := aws.StringValue(migrated["pk"].S), aws.StringValue(migrated["sk"].S)
+					if pk != aws.StringValue(item["pk"].S) || sk != aws.StringValue(item["sk"].S) {
+						return ctx.RewriteKey(migrated, pk, sk)
+					}
+					return ctx.PutItem(migrated)
+				})
+			},
+		},
+		{
+			ID:          "0005_typed_attributes",
+			Description: "Store prices, quantities and discounts as numbers, discontinued as boolean and dates in RFC 3339",
+			Up: func(ctx *MigrationContext) error {
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					migrated, changed, err := typedAttributes(item)
+					if err != nil || !changed {
+						return err
+					}
+					return ctx.PutItem(migrated)
+				})
+			},
+		},
+		{
+			ID:          "0006_binary_pictures",
+			Description: "Store the hex encoded pictures of categories and photos of employees as binary attributes",
+			Up: func(ctx *MigrationContext) error {
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					changed := false
+					for _, name := range []string{"picture", "photo"} {
+						value, ok := item[name]
+						if !ok || value.S == nil {
+							continue
+						}
+						data, err := ParseHexBinary(*value.S)
+						if err != nil {
+							return fmt.Errorf("invalid %v of item %v/%v: %v", name, aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S), err)
+						}
+						item[name] = &dynamodb.AttributeValue{B: data}
+						changed = true
+					}
+					if !changed {
+						return nil
+					}
+					return ctx.PutItem(item)
+				})
+			},
+		},
+		{
+			ID:          "0007_sparse_indexes",
+			Description: "Add the sparse index entries of products, customers and orders and remove data=1 of discontinued products",
+			Up: func(ctx *MigrationContext) error {
+				repository := NewRepository(ctx.DynamoDBClient, ctx.TableName, ctx.TableManager.schema)
+				classifier := NewEntityClassifier(EntityKeySchemas())
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					entityType := classifier.EntityType(item)
+					var writes []*dynamodb.TransactWriteItem
+					if entityType == productPrefix && stringAttribute(item, "data") == "1" {
+						item = withAttribute(item, "data", nil)
+						writes = append(writes, ctx.putWrite(item))
+					}
+					writes = append(writes, repository.sparseIndexWrites(entityType, item, func(index *SparseIndex) bool {
+						return true
+					})...)
+					return ctx.TransactWriteItems(writes)
+				})
+			},
+			Down: func(ctx *MigrationContext) error {
+				repository := NewRepository(ctx.DynamoDBClient, ctx.TableName, ctx.TableManager.schema)
+				classifier := NewEntityClassifier(EntityKeySchemas())
+				return ctx.ScanItems(func(item map[string]*dynamodb.AttributeValue) error {
+					entityType := classifier.EntityType(item)
+					writes := repository.sparseIndexDeletes(entityType, item["pk"])
+					if entityType == productPrefix && boolAttribute(item, "discontinued") {
+						writes = append(writes, ctx.putWrite(withAttribute(item, "data", &dynamodb.AttributeValue{S: aws.String("1")})))
+					}
+					return ctx.TransactWriteItems(writes)
+				})
+			},
+		},
+	}
+}
+
+// withAttribute returns a copy of the item with the attribute set to value, or removed if value is nil
+func withAttribute(item map[string]*dynamodb.AttributeValue, name string, value *dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	copied := make(map[string]*dynamodb.AttributeValue, len(item))
+	for attribute, attributeValue := range item {
+		copied[attribute] = attributeValue
+	}
+	if value == nil {
+		delete(copied, name)
+	} else {
+		copied[name] = value
+	}
+	return copied
+}
+
+// fixedCustomerPk returns the customer pk written as customers#%!d(string=ID) as customers#ID
+func fixedCustomerPk(pk string) (string, bool) {
+	brokenPrefix := customerPrefix + "#%!d(string="
+	if !strings.HasPrefix(pk, brokenPrefix) {
+		return "", false
+	}
+	return fmt.Sprintf("%s#%s", customerPrefix, strings.TrimSuffix(strings.TrimPrefix(pk, brokenPrefix), ")")), true
+}
+
+// prefixedSortKey returns the sk of a customer or shipper prefixed with its entity type
+func prefixedSortKey(pk string, sk string) (string, bool) {
+	for _, prefix := range []string{customerPrefix, shipperPrefix} {
+		if strings.HasPrefix(pk, prefix+"#") && !strings.HasPrefix(sk, prefix+"#") && !strings.HasPrefix(sk, sparseIndexPrefix+"#") {
+			return fmt.Sprintf("%s#%s", prefix, sk), true
+		}
+	}
+	return "", false
+}
+
+// unprefixedSortKey reverts prefixedSortKey
+func unprefixedSortKey(pk string, sk string) (string, bool) {
+	for _, prefix := range []string{customerPrefix, shipperPrefix} {
+		if strings.HasPrefix(pk, prefix+"#") && strings.HasPrefix(sk, prefix+"#") {
+			return strings.TrimPrefix(sk, prefix+"#"), true
+		}
+	}
+	return "", false
+}
+
+// typedAttributes returns the item with the string values of typed attributes converted. A data attribute holding
+// one of the converted values, the hire date of employees and the unit price of order details, is converted as well.
+// Dates already in RFC 3339, as written by the current loader, are left unchanged.
+func typedAttributes(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, bool, error) {
+	converted := map[string]*dynamodb.AttributeValue{}
+	convert := func(names []string, fn func(value string) (*dynamodb.AttributeValue, error)) error {
+		for _, name := range names {
+			value, ok := item[name]
+			if !ok || value.S == nil {
+				continue
+			}
+			typed, err := fn(*value.S)
+			if err != nil {
+				return fmt.Errorf("invalid %v of item %v/%v: %v", name, aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S), err)
+			}
+			if typed != nil {
+				converted[name] = typed
+			}
+		}
+		return nil
+	}
+
+	err := convert(decimalAttributes, func(value string) (*dynamodb.AttributeValue, error) {
+		decimal, err := ParseDecimal(value)
+		return &dynamodb.AttributeValue{N: aws.String(decimal.String())}, err
+	})
+	if err == nil {
+		err = convert(integerAttributes, func(value string) (*dynamodb.AttributeValue, error) {
+			integer, err := strconv.Atoi(value)
+			return &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(integer))}, err
+		})
+	}
+	if err == nil {
+		err = convert([]string{"discontinued"}, func(value string) (*dynamodb.AttributeValue, error) {
+			boolean, err := strconv.ParseBool(value)
+			return &dynamodb.AttributeValue{BOOL: aws.Bool(boolean)}, err
+		})
+	}
+	if err == nil {
+		err = convert(dateAttributes, func(value string) (*dynamodb.AttributeValue, error) {
+			if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				return nil, nil
+			}
+			date, err := time.Parse(legacyDateLayout, value)
+			return &dynamodb.AttributeValue{S: aws.String(formatTime(date))}, err
+		})
+	}
+	if err != nil || len(converted) == 0 {
+		return nil, false, err
+	}
+
+	migrated := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		migrated[name] = value
+	}
+	for name, value := range converted {
+		if data := item["data"]; data != nil && data.S != nil && *data.S == aws.StringValue(item[name].S) {
+			migrated["data"] = &dynamodb.AttributeValue{S: aws.String(aws.StringValue(value.S) + aws.StringValue(value.N))}
+		}
+		migrated[name] = value
+	}
+	return migrated, true, nil
+}
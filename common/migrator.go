@@ -0,0 +1,532 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	metaPrefix       = "_meta"
+	migrationsPk     = metaPrefix + "#migrations"
+	migrationsSk     = metaPrefix + "#applied"
+	migrationsLockSk = metaPrefix + "#lock"
+	// The lock expires migrationsLockTime after it was last renewed, it is renewed every migrationsLockRenewal
+	migrationsLockTime    = 10 * time.Minute
+	migrationsLockRenewal = time.Minute
+)
+
+var errMigrationLockLost = errors.New("migration lock was lost")
+
+// Migration changes the data layout of the table. Migrations are applied in the order of their IDs and recorded
+// in the metadata item pk=_meta#migrations of the table. A migration without Down cannot be reverted.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx *MigrationContext) error
+	Down        func(ctx *MigrationContext) error
+}
+
+type MigrationContext struct {
+	DynamoDBClient dynamodbiface.DynamoDBAPI
+	TableName      string
+	TableManager   *TableManager
+	lock           *migrationLock
+}
+
+// migrationLock is closed when the runner lost the migration lock of the table
+type migrationLock struct {
+	lost chan struct{}
+}
+
+func (l *migrationLock) check() error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.lost:
+		return errMigrationLockLost
+	default:
+		return nil
+	}
+}
+
+type MigrationStatus struct {
+	ID          string
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+type appliedMigration struct {
+	ID        string `dynamodbav:"id"`
+	AppliedAt string `dynamodbav:"appliedAt"`
+}
+
+type migrationsRecord struct {
+	Pk      string              `dynamodbav:"pk"`
+	Sk      string              `dynamodbav:"sk"`
+	Applied []*appliedMigration `dynamodbav:"applied"`
+}
+
+type Migrator struct {
+	dynamoDBClient dynamodbiface.DynamoDBAPI
+	tableName      string
+	tableManager   *TableManager
+	migrations     []*Migration
+	owner          string
+}
+
+func NewMigrator(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string, tableManager *TableManager, migrations []*Migration) *Migrator {
+	sorted := append([]*Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	hostname, _ := os.Hostname()
+	return &Migrator{
+		dynamoDBClient: dynamoDBClient,
+		tableName:      tableName,
+		tableManager:   tableManager,
+		migrations:     sorted,
+		owner:          fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	for _, migration := range m.migrations {
+		status := &MigrationStatus{
+			ID:          migration.ID,
+			Description: migration.Description,
+		}
+		if appliedMigration, ok := applied[migration.ID]; ok {
+			status.Applied = true
+			status.AppliedAt, _ = time.Parse(time.RFC3339, appliedMigration.AppliedAt)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Up applies all pending migrations in order, or only those up to and including target if it is not empty
+func (m *Migrator) Up(target string) error {
+	if target != "" && m.migration(target) == nil {
+		return fmt.Errorf("unknown migration %v", target)
+	}
+
+	return m.withLock(func(lock *migrationLock) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.ID]; !ok {
+				err := m.run(migration, migration.Up, "Applying", lock)
+				if err != nil {
+					return err
+				}
+				err = m.recordApplied(migration.ID)
+				if err != nil {
+					return err
+				}
+			}
+			if migration.ID == target {
+				break
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the given number of most recently applied migrations
+func (m *Migrator) Down(steps int) error {
+	return m.withLock(func(lock *migrationLock) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.ID]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %v cannot be reverted", migration.ID)
+			}
+
+			err := m.run(migration, migration.Down, "Reverting", lock)
+			if err != nil {
+				return err
+			}
+			err = m.recordReverted(migration.ID)
+			if err != nil {
+				return err
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) run(migration *Migration, step func(ctx *MigrationContext) error, action string, lock *migrationLock) error {
+	logger := log.WithFields(log.Fields{
+		"table":     m.tableName,
+		"migration": migration.ID,
+	})
+	logger.Infof("%s migration", action)
+
+	err := step(&MigrationContext{
+		DynamoDBClient: m.dynamoDBClient,
+		TableName:      m.tableName,
+		TableManager:   m.tableManager,
+		lock:           lock,
+	})
+	if err == nil {
+		// A migration which only waited for DynamoDB, e.g. for an index, is not recorded without the lock either
+		err = lock.check()
+	}
+	if err != nil {
+		return fmt.Errorf("migration %v failed: %v", migration.ID, err)
+	}
+
+	logger.Infof("Finished %s migration", action)
+	return nil
+}
+
+func (m *Migrator) migration(id string) *Migration {
+	for _, migration := range m.migrations {
+		if migration.ID == id {
+			return migration
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied() (map[string]*appliedMigration, error) {
+	record, err := m.migrationsRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[string]*appliedMigration{}
+	for _, migration := range record.Applied {
+		applied[migration.ID] = migration
+	}
+	return applied, nil
+}
+
+func (m *Migrator) migrationsRecord() (*migrationsRecord, error) {
+	output, err := m.dynamoDBClient.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(m.tableName),
+		Key:            metaKey(migrationsSk),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations from dynamodb: %v", err)
+	}
+
+	record := &migrationsRecord{
+		Pk: migrationsPk,
+		Sk: migrationsSk,
+	}
+	if output.Item != nil {
+		err = dynamodbattribute.UnmarshalMap(output.Item, record)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling applied migrations from DynamoDB: %v", err)
+		}
+	}
+	return record, nil
+}
+
+func (m *Migrator) recordApplied(id string) error {
+	record, err := m.migrationsRecord()
+	if err != nil {
+		return err
+	}
+	record.Applied = append(record.Applied, &appliedMigration{
+		ID:        id,
+		AppliedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	return m.storeMigrationsRecord(record)
+}
+
+func (m *Migrator) recordReverted(id string) error {
+	record, err := m.migrationsRecord()
+	if err != nil {
+		return err
+	}
+	var applied []*appliedMigration
+	for _, migration := range record.Applied {
+		if migration.ID != id {
+			applied = append(applied, migration)
+		}
+	}
+	record.Applied = applied
+	return m.storeMigrationsRecord(record)
+}
+
+func (m *Migrator) storeMigrationsRecord(record *migrationsRecord) error {
+	attributeValues, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+	}
+
+	_, err = m.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(m.tableName),
+		Item:      attributeValues,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save applied migrations to dynamodb: %v", err)
+	}
+	return nil
+}
+
+// withLock runs fn while holding the migration lock item of the table. The lock is renewed in the background while
+// fn runs. A lock which was not renewed within migrationsLockTime is considered abandoned and taken over, and if the
+// lock cannot be renewed, the lock passed to fn is marked as lost so that the running migration stops.
+func (m *Migrator) withLock(fn func(lock *migrationLock) error) error {
+	now := time.Now()
+	item := metaKey(migrationsLockSk)
+	item["owner"] = &dynamodb.AttributeValue{
+		S: aws.String(m.owner),
+	}
+	item["expiresAt"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(now.Add(migrationsLockTime).Unix(), 10)),
+	}
+
+	_, err := m.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(m.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR expiresAt < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+		},
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return fmt.Errorf("migrations of table %v are locked by another runner", m.tableName)
+		}
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+
+	lock := &migrationLock{lost: make(chan struct{})}
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		m.renewLock(lock, stop)
+	}()
+
+	defer func() {
+		close(stop)
+		<-renewed
+		_, err := m.dynamoDBClient.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName:           aws.String(m.tableName),
+			Key:                 metaKey(migrationsLockSk),
+			ConditionExpression: aws.String("#owner = :owner"),
+			ExpressionAttributeNames: map[string]*string{
+				"#owner": aws.String("owner"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":owner": {
+					S: aws.String(m.owner),
+				},
+			},
+		})
+		if err != nil && lock.check() == nil {
+			log.WithError(err).WithField("table", m.tableName).Error("Could not release migration lock")
+		}
+	}()
+
+	return fn(lock)
+}
+
+// renewLock extends the expiry of the lock every migrationsLockRenewal until stop is closed. The lock is lost if
+// another runner took it over or if it could not be renewed before it expires.
+func (m *Migrator) renewLock(lock *migrationLock, stop <-chan struct{}) {
+	ticker := time.NewTicker(migrationsLockRenewal)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			_, err := m.dynamoDBClient.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:           aws.String(m.tableName),
+				Key:                 metaKey(migrationsLockSk),
+				UpdateExpression:    aws.String("SET expiresAt = :expiresAt"),
+				ConditionExpression: aws.String("#owner = :owner"),
+				ExpressionAttributeNames: map[string]*string{
+					"#owner": aws.String("owner"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":owner": {
+						S: aws.String(m.owner),
+					},
+					":expiresAt": {
+						N: aws.String(strconv.FormatInt(now.Add(migrationsLockTime).Unix(), 10)),
+					},
+				},
+			})
+			if err == nil {
+				renewed = now
+				continue
+			}
+
+			logger := log.WithError(err).WithField("table", m.tableName)
+			awsErr, ok := err.(awserr.Error)
+			if (ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException) || now.Sub(renewed) >= migrationsLockTime-migrationsLockRenewal {
+				logger.Error("Lost migration lock, stopping the migration")
+				close(lock.lost)
+				return
+			}
+			logger.Warn("Could not renew migration lock")
+		}
+	}
+}
+
+func metaKey(sk string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(migrationsPk),
+		},
+		"sk": {
+			S: aws.String(sk),
+		},
+	}
+}
+
+// ScanItems calls fn for every item of the table except the metadata items
+func (ctx *MigrationContext) ScanItems(fn func(item map[string]*dynamodb.AttributeValue) error) error {
+	var fnErr error
+	err := ctx.DynamoDBClient.ScanPages(&dynamodb.ScanInput{
+		TableName:        aws.String(ctx.TableName),
+		FilterExpression: aws.String("NOT begins_with(pk, :meta)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":meta": {
+				S: aws.String(metaPrefix + "#"),
+			},
+		},
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		fnErr = ctx.lock.check()
+		if fnErr != nil {
+			return false
+		}
+		for _, item := range output.Items {
+			fnErr = fn(item)
+			if fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning dynamoDB table %v: %v", ctx.TableName, err)
+	}
+	return fnErr
+}
+
+// PutItem replaces an item with the same primary key
+func (ctx *MigrationContext) PutItem(item map[string]*dynamodb.AttributeValue) error {
+	err := ctx.lock.check()
+	if err != nil {
+		return err
+	}
+	_, err = ctx.DynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(ctx.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put item %v/%v: %v", aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S), err)
+	}
+	return nil
+}
+
+// TransactWriteItems writes the items in a single transaction, nothing is written if there are none
+func (ctx *MigrationContext) TransactWriteItems(writes []*dynamodb.TransactWriteItem) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	err := ctx.lock.check()
+	if err != nil {
+		return err
+	}
+	_, err = ctx.DynamoDBClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: writes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %v items: %v", len(writes), err)
+	}
+	return nil
+}
+
+func (ctx *MigrationContext) putWrite(item map[string]*dynamodb.AttributeValue) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName: aws.String(ctx.TableName),
+			Item:      item,
+		},
+	}
+}
+
+// RewriteKey replaces an item by a copy with a different primary key in a single transaction
+func (ctx *MigrationContext) RewriteKey(item map[string]*dynamodb.AttributeValue, pk string, sk string) error {
+	rewritten := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		rewritten[name] = value
+	}
+	rewritten["pk"] = &dynamodb.AttributeValue{S: aws.String(pk)}
+	rewritten["sk"] = &dynamodb.AttributeValue{S: aws.String(sk)}
+
+	err := ctx.lock.check()
+	if err != nil {
+		return err
+	}
+	_, err = ctx.DynamoDBClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(ctx.TableName),
+					Item:      rewritten,
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(ctx.TableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"pk": item["pk"],
+						"sk": item["sk"],
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rewrite key of item %v/%v: %v", aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S), err)
+	}
+	return nil
+}
@@ -1,10 +1,12 @@
 package common
 
+import "time"
+
 type Category struct {
 	CategoryID   int
 	CategoryName string
 	Description  string
-	Picture      string
+	Picture      []byte
 }
 
 type Customer struct {
@@ -27,8 +29,8 @@ type Employee struct {
 	FirstName       string
 	Title           string
 	TitleOfCourtesy string
-	BirthDate       string
-	HireDate        string
+	BirthDate       time.Time
+	HireDate        time.Time
 	Address         string
 	City            string
 	Region          string
@@ -36,7 +38,7 @@ type Employee struct {
 	Country         string
 	HomePhone       string
 	Extension       string
-	Photo           string
+	Photo           []byte
 	Notes           string
 	ReportsTo       string
 	PhotoPath       string
@@ -45,20 +47,20 @@ type Employee struct {
 type OrderDetail struct {
 	OrderID   int
 	ProductID int
-	UnitPrice string
-	Quantity  string
-	Discount  string
+	UnitPrice Decimal
+	Quantity  *int
+	Discount  Decimal
 }
 
 type Order struct {
 	OrderID        int
 	CustomerID     string
 	EmployeeID     int
-	OrderDate      string
-	RequiredDate   string
-	ShippedDate    string
+	OrderDate      time.Time
+	RequiredDate   time.Time
+	ShippedDate    time.Time
 	ShipVia        string
-	Freight        string
+	Freight        Decimal
 	ShipName       string
 	ShipAddress    string
 	ShipCity       string
@@ -73,11 +75,11 @@ type Product struct {
 	SupplierID      int
 	CategoryID      int
 	QuantityPerUnit string
-	UnitPrice       string
-	UnitsInStock    string
-	UnitsOnOrder    string
-	ReorderLevel    string
-	Discontinued    string
+	UnitPrice       Decimal
+	UnitsInStock    *int
+	UnitsOnOrder    *int
+	ReorderLevel    *int
+	Discontinued    *bool
 }
 
 type Shipper struct {
@@ -100,3 +102,8 @@ type Supplier struct {
 	Fax          string
 	HomePage     string
 }
+
+type IdempotencyRecord struct {
+	Key      string
+	Response string
+}
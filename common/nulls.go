@@ -0,0 +1,131 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// MissingValuesAbsent leaves missing values out of the items
+	MissingValuesAbsent = "absent"
+	// MissingValuesNull stores missing values as DynamoDB NULL attributes
+	MissingValuesNull = "null"
+
+	// MissingKeyPart replaces a missing value in a key, e.g. employees#- for an employee without manager, so that
+	// composite keys keep their number of parts and stay queryable with begins_with
+	MissingKeyPart = "-"
+
+	// nullToken is the literal the Northwind data set uses for missing values, older loads stored it as is
+	nullToken = "NULL"
+)
+
+// SetMissingValues selects how the Store methods write empty model fields, MissingValuesAbsent or MissingValuesNull
+func (r *Repository) SetMissingValues(mode string) error {
+	if mode != MissingValuesAbsent && mode != MissingValuesNull {
+		return fmt.Errorf("unknown missing values mode %v", mode)
+	}
+	r.missingValues = mode
+	return nil
+}
+
+// marshalRecord marshals a DynamoDB record, whose fields with missing values are omitted or written as NULL
+func (r *Repository) marshalRecord(record interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	attributeValues, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(record))
+	for i := 0; i < value.NumField(); i++ {
+		if !isMissingValue(value.Field(i)) {
+			continue
+		}
+		name := strings.Split(value.Type().Field(i).Tag.Get("dynamodbav"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if r.missingValues == MissingValuesNull {
+			attributeValues[name] = &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+		} else {
+			delete(attributeValues, name)
+		}
+	}
+	return attributeValues, nil
+}
+
+// isMissingValue reports whether a model field holds a missing value, an empty string or binary, a zero time, a
+// missing decimal or a nil number or boolean
+func isMissingValue(field reflect.Value) bool {
+	if field.Kind() == reflect.Ptr {
+		return field.IsNil()
+	}
+	switch value := field.Interface().(type) {
+	case string:
+		return value == ""
+	case []byte:
+		return len(value) == 0
+	case time.Time:
+		return value.IsZero()
+	case Decimal:
+		return value.IsMissing()
+	}
+	return false
+}
+
+// keyPart returns the value for use in a key, MissingKeyPart if it is missing
+func keyPart(value string) string {
+	if isEmptyValue(value) {
+		return MissingKeyPart
+	}
+	return value
+}
+
+// compositeKey joins the parts of a composite key, replacing missing parts with MissingKeyPart
+func compositeKey(parts ...string) string {
+	keyParts := make([]string, len(parts))
+	for i, part := range parts {
+		keyParts[i] = keyPart(part)
+	}
+	return strings.Join(keyParts, "#")
+}
+
+// withoutNullTokens returns the item without string attributes holding the literal NULL and with NULL key parts
+// replaced by MissingKeyPart. It reports whether the item changed.
+func withoutNullTokens(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, bool) {
+	changed := false
+	result := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		switch {
+		case name == "pk" || name == "sk" || name == "data":
+			if value.S != nil {
+				parts := strings.Split(*value.S, "#")
+				for i, part := range parts {
+					if part == nullToken {
+						parts[i] = MissingKeyPart
+						changed = true
+					}
+				}
+				value = &dynamodb.AttributeValue{S: aws.String(strings.Join(parts, "#"))}
+			}
+			result[name] = value
+		case aws.StringValue(value.S) == nullToken:
+			changed = true
+		default:
+			result[name] = value
+		}
+	}
+	return result, changed
+}
+
+// formatTime formats a time as the DynamoDB marshaller does, a zero time is a missing value
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
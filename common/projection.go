@@ -0,0 +1,201 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	batchGetItemLimit   = 100
+	batchGetItemRetries = 5
+)
+
+// Records stored per entity type, used to find out which attributes a complete entity consists of
+var entityRecords = map[string]interface{}{
+	categoryPrefix:    DynamoDBCategory{},
+	customerPrefix:    DynamoDBCustomer{},
+	employeePrefix:    DynamoDBEmployee{},
+	idempotencyPrefix: DynamoDBIdempotencyRecord{},
+	orderDetailPrefix: DynamoDBOrderDetail{},
+	orderPrefix:       DynamoDBOrder{},
+	productPrefix:     DynamoDBProduct{},
+	shipperPrefix:     DynamoDBShipper{},
+	supplierPrefix:    DynamoDBSupplier{},
+}
+
+// RepositoryMetrics counts how often index reads had to be completed from the base table
+type RepositoryMetrics struct {
+	IndexQueries    int64
+	FallbackFetches int64
+	FallbackItems   int64
+}
+
+func (r *Repository) Metrics() RepositoryMetrics {
+	return RepositoryMetrics{
+		IndexQueries:    atomic.LoadInt64(&r.metrics.IndexQueries),
+		FallbackFetches: atomic.LoadInt64(&r.metrics.FallbackFetches),
+		FallbackItems:   atomic.LoadInt64(&r.metrics.FallbackItems),
+	}
+}
+
+// completeItems returns complete, unexpired items of the given entity type for items read from an index. If the
+// index does not project all attributes of the entity type, the items are fetched from the base table with
+// BatchGetItem.
+func (r *Repository) completeItems(indexName string, entityType string, items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	atomic.AddInt64(&r.metrics.IndexQueries, 1)
+
+	index := r.schema.Index(indexName)
+	if index == nil {
+		return nil, fmt.Errorf("table schema has no index %v", indexName)
+	}
+	if len(items) == 0 || r.projectsEntity(index, entityType) {
+		return r.fetchBlobs(r.unexpiredItems(items))
+	}
+
+	atomic.AddInt64(&r.metrics.FallbackFetches, 1)
+	atomic.AddInt64(&r.metrics.FallbackItems, int64(len(items)))
+
+	fetched := map[string]map[string]*dynamodb.AttributeValue{}
+	for start := 0; start < len(items); start += batchGetItemLimit {
+		end := start + batchGetItemLimit
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var keys []map[string]*dynamodb.AttributeValue
+		for _, item := range items[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				r.schema.PartitionKey: item[r.schema.PartitionKey],
+				r.schema.SortKey:      item[r.schema.SortKey],
+			})
+		}
+
+		err := batchGetItems(r.dynamoDBClient, r.tableName, keys, func(item map[string]*dynamodb.AttributeValue) {
+			fetched[r.itemKey(item)] = item
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the order of the index, items deleted in the meantime are skipped
+	var completeItems []map[string]*dynamodb.AttributeValue
+	for _, item := range items {
+		if fetchedItem, ok := fetched[r.itemKey(item)]; ok {
+			completeItems = append(completeItems, fetchedItem)
+		}
+	}
+
+	return r.fetchBlobs(r.unexpiredItems(completeItems))
+}
+
+// batchGetItems gets up to 100 items with BatchGetItem, retrying unprocessed keys with exponential backoff
+func batchGetItems(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string, keys []map[string]*dynamodb.AttributeValue, handle func(item map[string]*dynamodb.AttributeValue)) error {
+	requestItems := map[string]*dynamodb.KeysAndAttributes{
+		tableName: {
+			Keys: keys,
+		},
+	}
+
+	backoff := 50 * time.Millisecond
+	for attempt := 0; len(requestItems) > 0; attempt++ {
+		if attempt > batchGetItemRetries {
+			return fmt.Errorf("failed to get %v items from dynamodb: retries exhausted", len(requestItems[tableName].Keys))
+		}
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		output, err := dynamoDBClient.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: requestItems,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get items from dynamodb: %v", err)
+		}
+
+		for _, item := range output.Responses[tableName] {
+			handle(item)
+		}
+		requestItems = output.UnprocessedKeys
+	}
+
+	return nil
+}
+
+// projectsEntity reports whether an index contains all attributes of the given entity type
+func (r *Repository) projectsEntity(index *IndexSchema, entityType string) bool {
+	switch index.ProjectionType {
+	case dynamodb.ProjectionTypeAll:
+		return true
+	case dynamodb.ProjectionTypeInclude:
+		record, ok := entityRecords[entityType]
+		if !ok {
+			return false
+		}
+		projected := map[string]bool{}
+		for _, attribute := range index.NonKeyAttributes {
+			projected[attribute] = true
+		}
+		for _, attribute := range recordAttributes(record) {
+			if !projected[attribute] {
+				return false
+			}
+		}
+		if _, ok := r.entityTTLs[entityType]; ok && !projected[expiresAtAttribute] {
+			return false
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Repository) itemKey(item map[string]*dynamodb.AttributeValue) string {
+	return fmt.Sprintf("%s|%s", aws.StringValue(item[r.schema.PartitionKey].S), aws.StringValue(item[r.schema.SortKey].S))
+}
+
+// projectedAttributes returns the attributes of all entity types except the given ones, e.g. to project everything
+// but large binary attributes into an index
+func projectedAttributes(exclude ...string) []string {
+	excluded := map[string]bool{}
+	for _, attribute := range exclude {
+		excluded[attribute] = true
+	}
+
+	attributes := map[string]bool{}
+	for _, record := range entityRecords {
+		for _, attribute := range recordAttributes(record) {
+			if !excluded[attribute] {
+				attributes[attribute] = true
+			}
+		}
+	}
+
+	var names []string
+	for attribute := range attributes {
+		names = append(names, attribute)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordAttributes returns the attribute names of the dynamodbav tags of a record struct
+func recordAttributes(record interface{}) []string {
+	recordType := reflect.TypeOf(record)
+	var attributes []string
+	for i := 0; i < recordType.NumField(); i++ {
+		name := strings.Split(recordType.Field(i).Tag.Get("dynamodbav"), ",")[0]
+		if name != "" && name != "-" {
+			attributes = append(attributes, name)
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// PurgeOptions selects the items removed by PurgeTable. Without an entity type and filter expression all items
+// except the migration history and lock in the _meta partition are removed.
+type PurgeOptions struct {
+	// Workers is the number of parallel scan segments
+	Workers int
+	// EntityType restricts the purge to items of one entity type together with their sparse index entries
+	EntityType                string
+	FilterExpression          string
+	ExpressionAttributeNames  map[string]*string
+	ExpressionAttributeValues map[string]*dynamodb.AttributeValue
+	// DryRun only counts the items that would be removed
+	DryRun bool
+	// IncludeMeta also removes the migration history and lock
+	IncludeMeta bool
+}
+
+type PurgeResult struct {
+	Scanned int64
+	Matched int64
+	Deleted int64
+}
+
+// PurgeTable removes the selected items with a parallel scan and verifies with a second, consistent scan that
+// none of them remain
+func (r *TableManager) PurgeTable(options PurgeOptions) (*PurgeResult, error) {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.EntityType != "" && !isEntityType(options.EntityType) {
+		return nil, fmt.Errorf("unknown entity type %v", options.EntityType)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"table":   r.tableName,
+		"entity":  options.EntityType,
+		"filter":  options.FilterExpression,
+		"workers": options.Workers,
+		"dry_run": options.DryRun,
+		"meta":    options.IncludeMeta,
+	})
+	logger.Info("Purging the dynamoDB table")
+
+	result := &PurgeResult{}
+	err := r.scanPurgedKeys(options, false, &result.Scanned, func(batchWriter *BatchWriter, key map[string]*dynamodb.AttributeValue) error {
+		atomic.AddInt64(&result.Matched, 1)
+		if options.DryRun {
+			return nil
+		}
+		return batchWriter.Delete(key)
+	}, func(batchWriter *BatchWriter) {
+		atomic.AddInt64(&result.Deleted, batchWriter.Written())
+	})
+	if err != nil {
+		return result, err
+	}
+
+	logger = logger.WithFields(log.Fields{
+		"scanned": result.Scanned,
+		"matched": result.Matched,
+		"deleted": result.Deleted,
+	})
+	if options.DryRun {
+		logger.Info("Dry run, no items deleted")
+		return result, nil
+	}
+
+	var scanned, remaining int64
+	err = r.scanPurgedKeys(options, true, &scanned, func(batchWriter *BatchWriter, key map[string]*dynamodb.AttributeValue) error {
+		atomic.AddInt64(&remaining, 1)
+		return nil
+	}, nil)
+	if err != nil {
+		return result, fmt.Errorf("could not verify purge of dynamoDB table %v: %v", r.tableName, err)
+	}
+	if remaining > 0 {
+		return result, fmt.Errorf("%v items remain in dynamoDB table %v after purge", remaining, r.tableName)
+	}
+
+	logger.Info("Purged table")
+
+	return result, nil
+}
+
+// scanPurgedKeys scans the table in parallel segments and calls handle with the key of every selected item. Each
+// segment has its own batch writer, which is flushed and passed to done when the segment is finished.
+func (r *TableManager) scanPurgedKeys(options PurgeOptions, consistentRead bool, scanned *int64, handle func(batchWriter *BatchWriter, key map[string]*dynamodb.AttributeValue) error, done func(batchWriter *BatchWriter)) error {
+	classifier := NewEntityClassifier(EntityKeySchemas())
+
+	errs := make([]error, options.Workers)
+	var wg sync.WaitGroup
+	for segment := 0; segment < options.Workers; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+
+			batchWriter := NewBatchWriter(r.dynamoDBClient, r.tableName)
+			var handleErr error
+			err := r.dynamoDBClient.ScanPages(r.purgeScanInput(options, consistentRead, segment), func(output *dynamodb.ScanOutput, lastPage bool) bool {
+				atomic.AddInt64(scanned, int64(len(output.Items)))
+				for _, item := range output.Items {
+					if !options.IncludeMeta && strings.HasPrefix(stringAttribute(item, r.schema.PartitionKey), metaPrefix+"#") {
+						continue
+					}
+					if options.EntityType != "" && purgedEntityType(classifier, item) != options.EntityType {
+						continue
+					}
+					handleErr = handle(batchWriter, map[string]*dynamodb.AttributeValue{
+						r.schema.PartitionKey: item[r.schema.PartitionKey],
+						r.schema.SortKey:      item[r.schema.SortKey],
+					})
+					if handleErr != nil {
+						return false
+					}
+				}
+				return true
+			})
+			if err == nil {
+				err = handleErr
+			}
+			if err == nil {
+				err = batchWriter.Flush()
+			}
+			if done != nil {
+				done(batchWriter)
+			}
+			if err != nil {
+				errs[segment] = fmt.Errorf("error purging segment %v of dynamoDB table %v: %v", segment, r.tableName, err)
+			}
+		}(segment)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *TableManager) purgeScanInput(options PurgeOptions, consistentRead bool, segment int) *dynamodb.ScanInput {
+	names := map[string]*string{
+		"#purge_pk": aws.String(r.schema.PartitionKey),
+		"#purge_sk": aws.String(r.schema.SortKey),
+	}
+	for name, value := range options.ExpressionAttributeNames {
+		names[name] = value
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(r.tableName),
+		ProjectionExpression:     aws.String("#purge_pk, #purge_sk"),
+		ExpressionAttributeNames: names,
+		ConsistentRead:           aws.Bool(consistentRead),
+		Segment:                  aws.Int64(int64(segment)),
+		TotalSegments:            aws.Int64(int64(options.Workers)),
+	}
+	if options.FilterExpression != "" {
+		input.FilterExpression = aws.String(options.FilterExpression)
+	}
+	if len(options.ExpressionAttributeValues) > 0 {
+		input.ExpressionAttributeValues = options.ExpressionAttributeValues
+	}
+	return input
+}
+
+// purgedEntityType returns the entity type of an item. Sparse index entries belong to the entity type of their
+// index, so that they are purged together with the items they refer to.
+func purgedEntityType(classifier *EntityClassifier, item map[string]*dynamodb.AttributeValue) string {
+	entityType := classifier.EntityType(item)
+	if entityType != sparseIndexPrefix {
+		return entityType
+	}
+	for _, index := range DefaultSparseIndexes() {
+		if stringAttribute(item, "sk") == sparseIndexKey(index.Name) {
+			return index.EntityType
+		}
+	}
+	return entityType
+}
+
+func isEntityType(entityType string) bool {
+	for _, entity := range EntityKeySchemas() {
+		if entity.EntityType == entityType {
+			return true
+		}
+	}
+	return false
+}
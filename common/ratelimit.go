@@ -0,0 +1,41 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the number of items written per second. It may be shared by multiple goroutines.
+type RateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter returns a limiter for the given number of items per second, or nil for no limit
+func NewRateLimiter(itemsPerSecond float64) *RateLimiter {
+	if itemsPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		interval: time.Duration(float64(time.Second) / itemsPerSecond),
+	}
+}
+
+// Wait blocks until the given number of items may be written
+func (l *RateLimiter) Wait(items int) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(items) * l.interval)
+	l.mutex.Unlock()
+
+	time.Sleep(wait)
+}
@@ -7,15 +7,19 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"strconv"
+	"time"
 )
 
 const (
-	categoryPrefix = "categories"
-	customerPrefix = "customers"
-	employeePrefix = "employees"
-	productPrefix  = "products"
-	shipperPrefix  = "shippers"
-	supplierPrefix = "suppliers"
+	categoryPrefix    = "categories"
+	customerPrefix    = "customers"
+	employeePrefix    = "employees"
+	idempotencyPrefix = "idempotency"
+	orderPrefix       = "orders"
+	orderDetailPrefix = "order_details"
+	productPrefix     = "products"
+	shipperPrefix     = "shippers"
+	supplierPrefix    = "suppliers"
 )
 
 // Internal records for DynamoDB
@@ -29,7 +33,7 @@ type DynamoDBCategory struct {
 	CategoryID   int    `dynamodbav:"categoryID,omitempty"`
 	CategoryName string `dynamodbav:"categoryName,omitempty"`
 	Description  string `dynamodbav:"description,omitempty"`
-	Picture      string `dynamodbav:"picture,omitempty"`
+	Picture      []byte `dynamodbav:"picture,omitempty"`
 }
 
 type DynamoDBCustomer struct {
@@ -47,62 +51,62 @@ type DynamoDBCustomer struct {
 }
 
 type DynamoDBEmployee struct {
-	EmployeeID      int    `dynamodbav:"employeeID,omitempty"`
-	LastName        string `dynamodbav:"lastName,omitempty"`
-	FirstName       string `dynamodbav:"firstName,omitempty"`
-	Title           string `dynamodbav:"title,omitempty"`
-	TitleOfCourtesy string `dynamodbav:"titleOfCourtesy,omitempty"`
-	BirthDate       string `dynamodbav:"birthDate,omitempty"`
-	HireDate        string `dynamodbav:"hireDate,omitempty"`
-	Address         string `dynamodbav:"address,omitempty"`
-	City            string `dynamodbav:"city,omitempty"`
-	Region          string `dynamodbav:"region,omitempty"`
-	PostalCode      string `dynamodbav:"postalCode,omitempty"`
-	Country         string `dynamodbav:"country,omitempty"`
-	HomePhone       string `dynamodbav:"homePhone,omitempty"`
-	Extension       string `dynamodbav:"extension,omitempty"`
-	Photo           string `dynamodbav:"photo,omitempty"`
-	Notes           string `dynamodbav:"notes,omitempty"`
-	ReportsTo       string `dynamodbav:"reportsTo,omitempty"`
-	PhotoPath       string `dynamodbav:"photoPath,omitempty"`
+	EmployeeID      int       `dynamodbav:"employeeID,omitempty"`
+	LastName        string    `dynamodbav:"lastName,omitempty"`
+	FirstName       string    `dynamodbav:"firstName,omitempty"`
+	Title           string    `dynamodbav:"title,omitempty"`
+	TitleOfCourtesy string    `dynamodbav:"titleOfCourtesy,omitempty"`
+	BirthDate       time.Time `dynamodbav:"birthDate,omitempty"`
+	HireDate        time.Time `dynamodbav:"hireDate,omitempty"`
+	Address         string    `dynamodbav:"address,omitempty"`
+	City            string    `dynamodbav:"city,omitempty"`
+	Region          string    `dynamodbav:"region,omitempty"`
+	PostalCode      string    `dynamodbav:"postalCode,omitempty"`
+	Country         string    `dynamodbav:"country,omitempty"`
+	HomePhone       string    `dynamodbav:"homePhone,omitempty"`
+	Extension       string    `dynamodbav:"extension,omitempty"`
+	Photo           []byte    `dynamodbav:"photo,omitempty"`
+	Notes           string    `dynamodbav:"notes,omitempty"`
+	ReportsTo       string    `dynamodbav:"reportsTo,omitempty"`
+	PhotoPath       string    `dynamodbav:"photoPath,omitempty"`
 }
 
 type DynamoDBOrderDetail struct {
-	OrderID   int    `dynamodbav:"orderID,omitempty"`
-	ProductID int    `dynamodbav:"productID,omitempty"`
-	UnitPrice string `dynamodbav:"unitPrice,omitempty"`
-	Quantity  string `dynamodbav:"quantity,omitempty"`
-	Discount  string `dynamodbav:"discount,omitempty"`
+	OrderID   int     `dynamodbav:"orderID,omitempty"`
+	ProductID int     `dynamodbav:"productID,omitempty"`
+	UnitPrice Decimal `dynamodbav:"unitPrice"`
+	Quantity  *int    `dynamodbav:"quantity"`
+	Discount  Decimal `dynamodbav:"discount"`
 }
 
 type DynamoDBOrder struct {
-	OrderID        int    `dynamodbav:"orderID,omitempty"`
-	CustomerID     string `dynamodbav:"customerID,omitempty"`
-	EmployeeID     int    `dynamodbav:"employeeID,omitempty"`
-	OrderDate      string `dynamodbav:"orderDate,omitempty"`
-	RequiredDate   string `dynamodbav:"requiredDate,omitempty"`
-	ShippedDate    string `dynamodbav:"shippedDate,omitempty"`
-	ShipVia        string `dynamodbav:"shipVia,omitempty"`
-	Freight        string `dynamodbav:"freight,omitempty"`
-	ShipName       string `dynamodbav:"shipName,omitempty"`
-	ShipAddress    string `dynamodbav:"shipAddress,omitempty"`
-	ShipCity       string `dynamodbav:"shipCity,omitempty"`
-	ShipRegion     string `dynamodbav:"shipRegion,omitempty"`
-	ShipPostalCode string `dynamodbav:"shipPostalCode,omitempty"`
-	ShipCountry    string `dynamodbav:"shipCountry,omitempty"`
+	OrderID        int       `dynamodbav:"orderID,omitempty"`
+	CustomerID     string    `dynamodbav:"customerID,omitempty"`
+	EmployeeID     int       `dynamodbav:"employeeID,omitempty"`
+	OrderDate      time.Time `dynamodbav:"orderDate,omitempty"`
+	RequiredDate   time.Time `dynamodbav:"requiredDate,omitempty"`
+	ShippedDate    time.Time `dynamodbav:"shippedDate,omitempty"`
+	ShipVia        string    `dynamodbav:"shipVia,omitempty"`
+	Freight        Decimal   `dynamodbav:"freight"`
+	ShipName       string    `dynamodbav:"shipName,omitempty"`
+	ShipAddress    string    `dynamodbav:"shipAddress,omitempty"`
+	ShipCity       string    `dynamodbav:"shipCity,omitempty"`
+	ShipRegion     string    `dynamodbav:"shipRegion,omitempty"`
+	ShipPostalCode string    `dynamodbav:"shipPostalCode,omitempty"`
+	ShipCountry    string    `dynamodbav:"shipCountry,omitempty"`
 }
 
 type DynamoDBProduct struct {
-	ProductID       int    `dynamodbav:"productID,omitempty"`
-	ProductName     string `dynamodbav:"productName,omitempty"`
-	SupplierID      int    `dynamodbav:"supplierID,omitempty"`
-	CategoryID      int    `dynamodbav:"categoryID,omitempty"`
-	QuantityPerUnit string `dynamodbav:"quantityPerUnit,omitempty"`
-	UnitPrice       string `dynamodbav:"unitPrice,omitempty"`
-	UnitsInStock    string `dynamodbav:"unitsInStock,omitempty"`
-	UnitsOnOrder    string `dynamodbav:"unitsOnOrder,omitempty"`
-	ReorderLevel    string `dynamodbav:"reorderLevel,omitempty"`
-	Discontinued    string `dynamodbav:"discontinued,omitempty"`
+	ProductID       int     `dynamodbav:"productID,omitempty"`
+	ProductName     string  `dynamodbav:"productName,omitempty"`
+	SupplierID      int     `dynamodbav:"supplierID,omitempty"`
+	CategoryID      int     `dynamodbav:"categoryID,omitempty"`
+	QuantityPerUnit string  `dynamodbav:"quantityPerUnit,omitempty"`
+	UnitPrice       Decimal `dynamodbav:"unitPrice"`
+	UnitsInStock    *int    `dynamodbav:"unitsInStock"`
+	UnitsOnOrder    *int    `dynamodbav:"unitsOnOrder"`
+	ReorderLevel    *int    `dynamodbav:"reorderLevel"`
+	Discontinued    *bool   `dynamodbav:"discontinued"`
 }
 
 type DynamoDBShipper struct {
@@ -126,23 +130,52 @@ type DynamoDBSupplier struct {
 	HomePage     string `dynamodbav:"homePage,omitempty"`
 }
 
+type DynamoDBIdempotencyRecord struct {
+	Key      string `dynamodbav:"key,omitempty"`
+	Response string `dynamodbav:"response,omitempty"`
+}
+
 type Repository struct {
-	dynamoDBClient dynamodbiface.DynamoDBAPI
-	tableName      string
+	dynamoDBClient   dynamodbiface.DynamoDBAPI
+	tableName        string
+	schema           *TableSchema
+	sparseIndexes    []*SparseIndex
+	metrics          RepositoryMetrics
+	consumedCapacity *ConsumedCapacity
+	entityTTLs       map[string]time.Duration
+	batchWriter      *BatchWriter
+	missingValues    string
+	newItems         bool
+	blobStore        BlobStore
+	blobThreshold    int
 }
 
-func NewRepository(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string) *Repository {
+func NewRepository(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string, schema *TableSchema) *Repository {
+	consumedCapacity := NewConsumedCapacity()
 	return &Repository{
-		dynamoDBClient: dynamoDBClient,
-		tableName:      tableName,
+		dynamoDBClient: &capacityRecordingClient{
+			DynamoDBAPI:      dynamoDBClient,
+			consumedCapacity: consumedCapacity,
+		},
+		tableName:        tableName,
+		schema:           schema,
+		sparseIndexes:    DefaultSparseIndexes(),
+		consumedCapacity: consumedCapacity,
+		entityTTLs:       DefaultEntityTTLs(),
+		missingValues:    MissingValuesAbsent,
 	}
 }
 
+// ConsumedCapacity returns the capacity consumed by the requests of the repository
+func (r *Repository) ConsumedCapacity() *ConsumedCapacity {
+	return r.consumedCapacity
+}
+
 func MarshalEmployee(employee *Employee) (map[string]*dynamodb.AttributeValue, error) {
 	record := &dynamodDbRecord{
 		Pk:   strconv.Itoa(employee.EmployeeID),
 		Sk:   employee.ReportsTo,
-		Data: employee.HireDate,
+		Data: formatTime(employee.HireDate),
 	}
 	attributeValues, err := dynamodbattribute.MarshalMap(record)
 	if err != nil {
@@ -152,94 +185,236 @@ func MarshalEmployee(employee *Employee) (map[string]*dynamodb.AttributeValue, e
 	return attributeValues, nil
 }
 
-func (r *Repository) StoreCategory(category *Category) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBCategory(*category))
+// Batch returns a copy of the repository whose Store methods buffer the items in a batch writer instead of writing
+// every item with its sparse index entries in a transaction. The copy must be used by a single goroutine and
+// flushed when done.
+func (r *Repository) Batch(rateLimiter *RateLimiter) *Repository {
+	batch := *r
+	batch.batchWriter = NewBatchWriter(r.dynamoDBClient, r.tableName)
+	batch.batchWriter.SetRateLimiter(rateLimiter)
+	return &batch
+}
+
+// Flush writes the items buffered by a repository returned by Batch
+func (r *Repository) Flush() error {
+	if r.batchWriter == nil {
+		return nil
+	}
+	return r.batchWriter.Flush()
+}
+
+// SetRow labels the items stored next by a repository returned by Batch with the row they belong to
+func (r *Repository) SetRow(row string) {
+	if r.batchWriter != nil {
+		r.batchWriter.SetRow(row)
+	}
+}
+
+// FailedRows returns the rows whose items a repository returned by Batch could not write since the last call
+func (r *Repository) FailedRows() map[string]error {
+	if r.batchWriter == nil {
+		return nil
+	}
+	return r.batchWriter.FailedRows()
+}
+
+// Written returns the number of items written by a repository returned by Batch, including sparse index entries
+func (r *Repository) Written() int64 {
+	if r.batchWriter == nil {
+		return 0
+	}
+	return r.batchWriter.Written()
+}
+
+// SetNewItems declares that the items stored by a repository returned by Batch do not exist in the table yet, so that
+// no sparse index entries of earlier versions of the items are deleted
+func (r *Repository) SetNewItems(newItems bool) {
+	r.newItems = newItems
+}
+
+// IsEmpty reports whether the table holds no items besides the migration history and lock
+func (r *Repository) IsEmpty() (bool, error) {
+	empty := true
+	err := r.dynamoDBClient.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String(r.tableName),
+		ProjectionExpression: aws.String("pk"),
+		FilterExpression:     aws.String("NOT begins_with(pk, :meta)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":meta": {
+				S: aws.String(metaPrefix + "#"),
+			},
+		},
+	}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		empty = len(output.Items) == 0
+		return empty
+	})
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return false, fmt.Errorf("error scanning dynamoDB table %v: %v", r.tableName, err)
 	}
-	attributeValues["pk"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s#%d", categoryPrefix, category.CategoryID)),
+	return empty, nil
+}
+
+// putItem stores an item and then brings the sparse index entries of its entity type in line with it. The previous
+// version of the item tells which entries have to be deleted.
+func (r *Repository) putItem(entityType string, attributeValues map[string]*dynamodb.AttributeValue) error {
+	r.setExpiry(entityType, attributeValues)
+	err := r.offloadBlobs(attributeValues)
+	if err != nil {
+		return err
 	}
-	attributeValues["sk"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s#%s", categoryPrefix, category.CategoryName)),
+
+	if r.batchWriter != nil {
+		// Batch writes cannot return the previous item, so entries are deleted unless the items are new
+		return r.batchPutItem(attributeValues, r.sparseIndexWrites(entityType, attributeValues, func(index *SparseIndex) bool {
+			return !r.newItems
+		}))
 	}
-	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(category.Description),
+
+	output, err := r.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName:    aws.String(r.tableName),
+		Item:         attributeValues,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save record to dynamodb: %v", err)
 	}
 
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
+	previous := output.Attributes
+	err = r.writeItems(r.sparseIndexWrites(entityType, attributeValues, func(index *SparseIndex) bool {
+		return previous != nil && index.Predicate(previous)
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to save sparse index entries to dynamodb: %v", err)
+	}
+
+	return nil
+}
+
+// writeItems writes a single item with PutItem or DeleteItem and several items in a transaction
+func (r *Repository) writeItems(writes []*dynamodb.TransactWriteItem) error {
+	switch {
+	case len(writes) == 0:
+		return nil
+	case len(writes) > 1:
+		_, err := r.dynamoDBClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: writes,
+		})
+		return err
+	case writes[0].Put != nil:
+		_, err := r.dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+			TableName: writes[0].Put.TableName,
+			Item:      writes[0].Put.Item,
+		})
+		return err
+	default:
+		_, err := r.dynamoDBClient.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: writes[0].Delete.TableName,
+			Key:       writes[0].Delete.Key,
+		})
+		return err
 	}
+}
 
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
+// batchPutItem buffers an item and its sparse index entries. A failed flush fails the rows of the flushed items, which
+// the batch writer records, so the remaining writes are buffered anyway and the first error is returned.
+func (r *Repository) batchPutItem(attributeValues map[string]*dynamodb.AttributeValue, sparseWrites []*dynamodb.TransactWriteItem) error {
+	err := r.batchWriter.Put(attributeValues)
+	for _, write := range sparseWrites {
+		var writeErr error
+		if write.Put != nil {
+			writeErr = r.batchWriter.Put(write.Put.Item)
+		} else {
+			writeErr = r.batchWriter.Delete(write.Delete.Key)
+		}
+		if err == nil {
+			err = writeErr
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("failed to save record to dynamodb: %v", err)
 	}
+	return nil
+}
+
+// deleteItem deletes an item together with the sparse index entries of its entity type in a single transaction
+func (r *Repository) deleteItem(entityType string, key map[string]*dynamodb.AttributeValue) error {
+	transactItems := append([]*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(r.tableName),
+				Key:       key,
+			},
+		},
+	}, r.sparseIndexDeletes(entityType, key["pk"])...)
+
+	_, err := r.dynamoDBClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete record from dynamodb: %v", err)
+	}
 
 	return nil
 }
 
-func (r *Repository) StoreCustomer(customer *Customer) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBCustomer(*customer))
+func (r *Repository) StoreCategory(category *Category) error {
+	attributeValues, err := r.marshalRecord(DynamoDBCategory(*category))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s#%d", customerPrefix, customer.CustomerID)),
+		S: aws.String(fmt.Sprintf("%s#%d", categoryPrefix, category.CategoryID)),
 	}
 	attributeValues["sk"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s", customer.ContactName)),
+		S: aws.String(fmt.Sprintf("%s#%s", categoryPrefix, keyPart(category.CategoryName))),
 	}
 	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s#%s#%s#%s", customer.Country, customer.Region, customer.City, customer.Address)),
+		S: aws.String(keyPart(category.Description)),
 	}
 
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
-	}
+	return r.putItem(categoryPrefix, attributeValues)
+}
 
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
+func (r *Repository) StoreCustomer(customer *Customer) error {
+	attributeValues, err := r.marshalRecord(DynamoDBCustomer(*customer))
 	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
+		return err
+	}
+	attributeValues["pk"] = &dynamodb.AttributeValue{
+		S: aws.String(fmt.Sprintf("%s#%s", customerPrefix, customer.CustomerID)),
+	}
+	attributeValues["sk"] = &dynamodb.AttributeValue{
+		S: aws.String(fmt.Sprintf("%s#%s", customerPrefix, keyPart(customer.ContactName))),
+	}
+	attributeValues["data"] = &dynamodb.AttributeValue{
+		S: aws.String(compositeKey(customer.Country, customer.Region, customer.City, customer.Address)),
 	}
 
-	return nil
+	return r.putItem(customerPrefix, attributeValues)
 }
 
 func (r *Repository) StoreEmployee(employee *Employee) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBEmployee(*employee))
+	attributeValues, err := r.marshalRecord(DynamoDBEmployee(*employee))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
 		S: aws.String(fmt.Sprintf("%s#%d", employeePrefix, employee.EmployeeID)),
 	}
 	attributeValues["sk"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s#%s", employeePrefix, employee.ReportsTo)),
+		S: aws.String(fmt.Sprintf("%s#%s", employeePrefix, keyPart(employee.ReportsTo))),
 	}
 	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(employee.HireDate),
+		S: aws.String(keyPart(formatTime(employee.HireDate))),
 	}
 
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
-	}
-
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
-	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
-	}
-
-	return nil
+	return r.putItem(employeePrefix, attributeValues)
 }
 
 func (r *Repository) StoreOrderDetail(orderDetail *OrderDetail) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBOrderDetail(*orderDetail))
+	attributeValues, err := r.marshalRecord(DynamoDBOrderDetail(*orderDetail))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
 		S: aws.String(fmt.Sprintf("%d", orderDetail.OrderID)),
@@ -248,26 +423,29 @@ func (r *Repository) StoreOrderDetail(orderDetail *OrderDetail) error {
 		S: aws.String(fmt.Sprintf("%s#%d", productPrefix, orderDetail.ProductID)),
 	}
 	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(orderDetail.UnitPrice),
+		S: aws.String(keyPart(orderDetail.UnitPrice.String())),
 	}
-
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
+	attributeValues["lsi_1_sk"] = &dynamodb.AttributeValue{
+		N: aws.String(orderLineValue(orderDetail)),
 	}
 
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
-	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
-	}
+	return r.putItem(orderDetailPrefix, attributeValues)
+}
 
-	return nil
+// orderLineValue returns unit price * quantity * (1 - discount) of an order line, which sorts the lines of an
+// order in lsi_1. Missing values count as zero.
+func orderLineValue(orderDetail *OrderDetail) string {
+	quantity := 0
+	if orderDetail.Quantity != nil {
+		quantity = *orderDetail.Quantity
+	}
+	return orderDetail.UnitPrice.MulInt(quantity).Mul(NewDecimal(1).Sub(orderDetail.Discount)).StringFixed(2)
 }
 
 func (r *Repository) StoreOrder(order *Order) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBOrder(*order))
+	attributeValues, err := r.marshalRecord(DynamoDBOrder(*order))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
 		S: aws.String(fmt.Sprintf("%d", order.OrderID)),
@@ -276,26 +454,16 @@ func (r *Repository) StoreOrder(order *Order) error {
 		S: aws.String("ORDER"),
 	}
 	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(order.CustomerID),
-	}
-
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
-	}
-
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
-	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
+		S: aws.String(keyPart(order.CustomerID)),
 	}
 
-	return nil
+	return r.putItem(orderPrefix, attributeValues)
 }
 
 func (r *Repository) StoreProduct(product *Product) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBProduct(*product))
+	attributeValues, err := r.marshalRecord(DynamoDBProduct(*product))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
 		S: aws.String(fmt.Sprintf("%s#%d", productPrefix, product.ProductID)),
@@ -303,57 +471,32 @@ func (r *Repository) StoreProduct(product *Product) error {
 	attributeValues["sk"] = &dynamodb.AttributeValue{
 		S: aws.String("PRODUCT"),
 	}
-	if product.Discontinued == "1" {
-		attributeValues["data"] = &dynamodb.AttributeValue{
-			S: aws.String("1"),
-		}
-	}
-
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
-	}
-
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
-	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
-	}
 
-	return nil
+	return r.putItem(productPrefix, attributeValues)
 }
 
 func (r *Repository) StoreShipper(shipper *Shipper) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBShipper(*shipper))
+	attributeValues, err := r.marshalRecord(DynamoDBShipper(*shipper))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
 		S: aws.String(fmt.Sprintf("%s#%d", shipperPrefix, shipper.ShipperID)),
 	}
 	attributeValues["sk"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s", shipper.CompanyName)),
+		S: aws.String(fmt.Sprintf("%s#%s", shipperPrefix, keyPart(shipper.CompanyName))),
 	}
 	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(shipper.Phone),
+		S: aws.String(keyPart(shipper.Phone)),
 	}
 
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
-	}
-
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
-	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
-	}
-
-	return nil
+	return r.putItem(shipperPrefix, attributeValues)
 }
 
 func (r *Repository) StoreSupplier(supplier *Supplier) error {
-	attributeValues, err := dynamodbattribute.MarshalMap(DynamoDBSupplier(*supplier))
+	attributeValues, err := r.marshalRecord(DynamoDBSupplier(*supplier))
 	if err != nil {
-		return fmt.Errorf("failed to DynamoDB marshal Record: %v", err)
+		return err
 	}
 	attributeValues["pk"] = &dynamodb.AttributeValue{
 		S: aws.String(fmt.Sprintf("%s#%d", supplierPrefix, supplier.SupplierID)),
@@ -362,22 +505,79 @@ func (r *Repository) StoreSupplier(supplier *Supplier) error {
 		S: aws.String("SUPPLIER"),
 	}
 	attributeValues["data"] = &dynamodb.AttributeValue{
-		S: aws.String(fmt.Sprintf("%s#%s#%s#%s", supplier.Country, supplier.Region, supplier.City, supplier.Address)),
+		S: aws.String(compositeKey(supplier.Country, supplier.Region, supplier.City, supplier.Address)),
 	}
 
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String(r.tableName),
-		Item:      attributeValues,
+	return r.putItem(supplierPrefix, attributeValues)
+}
+
+func (r *Repository) StoreIdempotencyRecord(idempotencyRecord *IdempotencyRecord) error {
+	attributeValues, err := r.marshalRecord(DynamoDBIdempotencyRecord(*idempotencyRecord))
+	if err != nil {
+		return err
+	}
+	attributeValues["pk"] = &dynamodb.AttributeValue{
+		S: aws.String(fmt.Sprintf("%s#%s", idempotencyPrefix, idempotencyRecord.Key)),
+	}
+	attributeValues["sk"] = &dynamodb.AttributeValue{
+		S: aws.String("IDEMPOTENCY"),
 	}
 
-	_, err = r.dynamoDBClient.PutItem(putItemInput)
+	return r.putItem(idempotencyPrefix, attributeValues)
+}
+
+// The customer sk holds the contact name, so the key of the customer item has to be looked up first
+func (r *Repository) DeleteCustomer(customerID string) error {
+	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("pk=:pk"),
+		FilterExpression:       aws.String("NOT begins_with(sk,:sparse)"),
+		ProjectionExpression:   aws.String("pk, sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(fmt.Sprintf("%s#%s", customerPrefix, customerID)),
+			},
+			":sparse": {
+				S: aws.String(sparseIndexPrefix + "#"),
+			},
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to save record to dynamodb: %v", err)
+		return fmt.Errorf("failed to query customer from dynamodb: %v", err)
+	}
+
+	for _, item := range output.Items {
+		err = r.deleteItem(customerPrefix, item)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (r *Repository) DeleteOrder(orderID int) error {
+	return r.deleteItem(orderPrefix, map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(fmt.Sprintf("%d", orderID)),
+		},
+		"sk": {
+			S: aws.String("ORDER"),
+		},
+	})
+}
+
+func (r *Repository) DeleteProduct(productID int) error {
+	return r.deleteItem(productPrefix, map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(fmt.Sprintf("%s#%d", productPrefix, productID)),
+		},
+		"sk": {
+			S: aws.String("PRODUCT"),
+		},
+	})
+}
+
 // Get employee by employee ID
 // table.query(KeyConditionExpression=Key('pk').eq('employees#2'))
 func (r *Repository) GetEmployee(employeeID int) (*Employee, error) {
@@ -394,11 +594,15 @@ func (r *Repository) GetEmployee(employeeID int) (*Employee, error) {
 		return nil, fmt.Errorf("failed to query employee from dynamodb: %v", err)
 	}
 
-	if len(output.Items) == 0 {
+	items, err := r.fetchBlobs(r.unexpiredItems(output.Items))
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
 		return nil, nil
 	}
 	record := &DynamoDBEmployee{}
-	err = dynamodbattribute.UnmarshalMap(output.Items[0], record)
+	err = dynamodbattribute.UnmarshalMap(items[0], record)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
 	}
@@ -407,6 +611,37 @@ func (r *Repository) GetEmployee(employeeID int) (*Employee, error) {
 	return &employee, nil
 }
 
+// Get an idempotency record by its key, nil if it does not exist or has expired
+// table.get_item(Key={'pk': 'idempotency#abc', 'sk': 'IDEMPOTENCY'})
+func (r *Repository) GetIdempotencyRecord(key string) (*IdempotencyRecord, error) {
+	output, err := r.dynamoDBClient.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(fmt.Sprintf("%s#%s", idempotencyPrefix, key)),
+			},
+			"sk": {
+				S: aws.String("IDEMPOTENCY"),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency record from dynamodb: %v", err)
+	}
+
+	if output.Item == nil || len(r.unexpiredItems([]map[string]*dynamodb.AttributeValue{output.Item})) == 0 {
+		return nil, nil
+	}
+	record := &DynamoDBIdempotencyRecord{}
+	err = dynamodbattribute.UnmarshalMap(output.Item, record)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
+	}
+
+	idempotencyRecord := IdempotencyRecord(*record)
+	return &idempotencyRecord, nil
+}
+
 // Get direct reports for an employee
 // table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('employees#2'))
 func (r *Repository) GetEmployeeDirectReports(employeeID int) ([]*Employee, error) {
@@ -424,9 +659,14 @@ func (r *Repository) GetEmployeeDirectReports(employeeID int) ([]*Employee, erro
 		return nil, fmt.Errorf("failed to query employee from dynamodb: %v", err)
 	}
 
+	items, err := r.completeItems("gsi_1", employeePrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
 	var employees []*Employee
 
-	for _, item := range output.Items {
+	for _, item := range items {
 		record := &DynamoDBEmployee{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -441,31 +681,16 @@ func (r *Repository) GetEmployeeDirectReports(employeeID int) ([]*Employee, erro
 }
 
 // Get discontinued products
-// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('PRODUCT') & Key('data').eq('1'))
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#discontinued-products'))
 func (r *Repository) GetProductsDiscontinued() ([]*Product, error) {
-	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
-		TableName:              aws.String(r.tableName),
-		IndexName:              aws.String("gsi_1"),
-		KeyConditionExpression: aws.String("sk=:sk AND #data=:data"),
-		ExpressionAttributeNames: map[string]*string{
-			"#data": aws.String("data"),
-		},
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":sk": {
-				S: aws.String("PRODUCT"),
-			},
-			":data": {
-				S: aws.String("1"),
-			},
-		},
-	})
+	items, err := r.ListSparse("discontinued-products")
 	if err != nil {
-		return nil, fmt.Errorf("failed to query products from dynamodb: %v", err)
+		return nil, err
 	}
 
 	var products []*Product
 
-	for _, item := range output.Items {
+	for _, item := range items {
 		record := &DynamoDBProduct{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -479,6 +704,54 @@ func (r *Repository) GetProductsDiscontinued() ([]*Product, error) {
 	return products, nil
 }
 
+// Get customers without a fax number
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#customers-without-fax'))
+func (r *Repository) GetCustomersWithoutFax() ([]*Customer, error) {
+	items, err := r.ListSparse("customers-without-fax")
+	if err != nil {
+		return nil, err
+	}
+
+	var customers []*Customer
+
+	for _, item := range items {
+		record := &DynamoDBCustomer{}
+		err = dynamodbattribute.UnmarshalMap(item, record)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
+		}
+
+		customer := Customer(*record)
+		customers = append(customers, &customer)
+	}
+
+	return customers, nil
+}
+
+// Get orders which have not been shipped yet
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#orders-awaiting-shipment'))
+func (r *Repository) GetOrdersAwaitingShipment() ([]*Order, error) {
+	items, err := r.ListSparse("orders-awaiting-shipment")
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []*Order
+
+	for _, item := range items {
+		record := &DynamoDBOrder{}
+		err = dynamodbattribute.UnmarshalMap(item, record)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
+		}
+
+		order := Order(*record)
+		orders = append(orders, &order)
+	}
+
+	return orders, nil
+}
+
 // List all orders of a given product
 // table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('products#1'))
 func (r *Repository) GetOrdersOfProduct(productID int) ([]*Order, error) {
@@ -496,9 +769,14 @@ func (r *Repository) GetOrdersOfProduct(productID int) ([]*Order, error) {
 		return nil, fmt.Errorf("failed to query orders from dynamodb: %v", err)
 	}
 
+	items, err := r.completeItems("gsi_1", orderDetailPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
 	var orders []*Order
 
-	for _, item := range output.Items {
+	for _, item := range items {
 		record := &DynamoDBOrder{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -530,9 +808,62 @@ func (r *Repository) GetOrdersRecent(limit int64) ([]*Order, error) {
 		return nil, fmt.Errorf("failed to query orders from dynamodb: %v", err)
 	}
 
+	items, err := r.completeItems("gsi_1", orderPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
 	var orders []*Order
 
-	for _, item := range output.Items {
+	for _, item := range items {
+		record := &DynamoDBOrder{}
+		err = dynamodbattribute.UnmarshalMap(item, record)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
+		}
+
+		order := Order(*record)
+		orders = append(orders, &order)
+	}
+
+	return orders, nil
+}
+
+// List all orders of a customer
+// table.query(IndexName='gsi_2',KeyConditionExpression=Key('data').eq('VINET') & Key('sk').eq('ORDER'))
+func (r *Repository) GetOrdersOfCustomer(customerID string) ([]*Order, error) {
+	if r.schema.Index("gsi_2") == nil {
+		return nil, fmt.Errorf("table schema has no index gsi_2")
+	}
+
+	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String("gsi_2"),
+		KeyConditionExpression: aws.String("#data=:data AND sk=:sk"),
+		ExpressionAttributeNames: map[string]*string{
+			"#data": aws.String("data"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":data": {
+				S: aws.String(customerID),
+			},
+			":sk": {
+				S: aws.String("ORDER"),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders from dynamodb: %v", err)
+	}
+
+	items, err := r.completeItems("gsi_2", orderPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []*Order
+
+	for _, item := range items {
 		record := &DynamoDBOrder{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -547,7 +878,7 @@ func (r *Repository) GetOrdersRecent(limit int64) ([]*Order, error) {
 }
 
 // Get shippers by name
-// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('United Package'))
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('shippers#United Package'))
 func (r *Repository) GetShippersByName(name string) ([]*Shipper, error) {
 	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
@@ -555,7 +886,7 @@ func (r *Repository) GetShippersByName(name string) ([]*Shipper, error) {
 		KeyConditionExpression: aws.String("sk=:sk"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":sk": {
-				S: aws.String(name),
+				S: aws.String(fmt.Sprintf("%s#%s", shipperPrefix, name)),
 			},
 		},
 	})
@@ -563,9 +894,14 @@ func (r *Repository) GetShippersByName(name string) ([]*Shipper, error) {
 		return nil, fmt.Errorf("failed to query orders from dynamodb: %v", err)
 	}
 
+	items, err := r.completeItems("gsi_1", shipperPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
 	var shippers []*Shipper
 
-	for _, item := range output.Items {
+	for _, item := range items {
 		record := &DynamoDBShipper{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -580,7 +916,7 @@ func (r *Repository) GetShippersByName(name string) ([]*Shipper, error) {
 }
 
 // Get customers by contact name
-// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('Maria Anders'))
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('customers#Maria Anders'))
 func (r *Repository) GetCustomersByContactName(contactName string) ([]*Customer, error) {
 	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
@@ -588,7 +924,7 @@ func (r *Repository) GetCustomersByContactName(contactName string) ([]*Customer,
 		KeyConditionExpression: aws.String("sk=:sk"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":sk": {
-				S: aws.String(contactName),
+				S: aws.String(fmt.Sprintf("%s#%s", customerPrefix, contactName)),
 			},
 		},
 	})
@@ -596,9 +932,14 @@ func (r *Repository) GetCustomersByContactName(contactName string) ([]*Customer,
 		return nil, fmt.Errorf("failed to query orders from dynamodb: %v", err)
 	}
 
+	items, err := r.completeItems("gsi_1", customerPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
 	var customers []*Customer
 
-	for _, item := range output.Items {
+	for _, item := range items {
 		record := &DynamoDBCustomer{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -633,7 +974,7 @@ func (r *Repository) GetProductsInOrder(orderId int) ([]*OrderDetail, error) {
 
 	var orderDetails []*OrderDetail
 
-	for _, item := range output.Items {
+	for _, item := range r.unexpiredItems(output.Items) {
 		record := &DynamoDBOrderDetail{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
@@ -647,8 +988,51 @@ func (r *Repository) GetProductsInOrder(orderId int) ([]*OrderDetail, error) {
 	return orderDetails, nil
 }
 
+// List all lines of an order, the most valuable line first
+// table.query(IndexName='lsi_1',KeyConditionExpression=Key('pk').eq('10260'),ScanIndexForward=False)
+func (r *Repository) GetOrderLinesByValue(orderID int) ([]*OrderDetail, error) {
+	if r.schema.Index("lsi_1") == nil {
+		return nil, fmt.Errorf("table schema has no index lsi_1")
+	}
+
+	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String("lsi_1"),
+		KeyConditionExpression: aws.String("pk=:pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(fmt.Sprintf("%d", orderID)),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order lines from dynamodb: %v", err)
+	}
+
+	items, err := r.completeItems("lsi_1", orderDetailPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var orderDetails []*OrderDetail
+
+	for _, item := range items {
+		record := &DynamoDBOrderDetail{}
+		err = dynamodbattribute.UnmarshalMap(item, record)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling record from DynamoDB: %v", err)
+		}
+
+		orderDetail := OrderDetail(*record)
+		orderDetails = append(orderDetails, &orderDetail)
+	}
+
+	return orderDetails, nil
+}
+
 // Get suppliers by country and region
-// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('SUPPLIER') & Key('data').begins_with('Germany#NULL'))
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('SUPPLIER') & Key('data').begins_with('Germany#-'))
 func (r *Repository) GetSuppliersByCountry(country string) ([]*Supplier, error) {
 	output, err := r.dynamoDBClient.Query(&dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
@@ -670,9 +1054,14 @@ func (r *Repository) GetSuppliersByCountry(country string) ([]*Supplier, error)
 		return nil, fmt.Errorf("failed to query orders from dynamodb: %v", err)
 	}
 
+	items, err := r.completeItems("gsi_1", supplierPrefix, output.Items)
+	if err != nil {
+		return nil, err
+	}
+
 	var suppliers []*Supplier
 
-	for _, item := range output.Items {
+	for _, item := range items {
 		record := &DynamoDBSupplier{}
 		err = dynamodbattribute.UnmarshalMap(item, record)
 		if err != nil {
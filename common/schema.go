@@ -0,0 +1,268 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// TableSchema is the declarative definition of the single table. TableManager creates and updates the table from
+// it and the repository uses it to resolve the key attributes of the indexes it queries.
+type TableSchema struct {
+	PartitionKey           string
+	SortKey                string
+	Attributes             map[string]string
+	GlobalSecondaryIndexes []*IndexSchema
+	LocalSecondaryIndexes  []*IndexSchema
+	// BillingMode of the table. If empty, tables are created on-demand and the billing mode and throughput of
+	// existing tables are left unchanged.
+	BillingMode         string
+	ReadCapacity        int64
+	WriteCapacity       int64
+	TimeToLiveAttribute string
+	// StreamViewType enables DynamoDB Streams with the given view type, streams are disabled if empty
+	StreamViewType string
+}
+
+// IndexSchema describes the base table or a secondary index. ReadCapacity and WriteCapacity of global secondary
+// indexes default to the capacity of the table in provisioned mode.
+type IndexSchema struct {
+	Name             string
+	PartitionKey     string
+	SortKey          string
+	ProjectionType   string
+	NonKeyAttributes []string
+	ReadCapacity     int64
+	WriteCapacity    int64
+}
+
+func DefaultTableSchema() *TableSchema {
+	return &TableSchema{
+		PartitionKey: "pk",
+		SortKey:      "sk",
+		Attributes: map[string]string{
+			"pk":       dynamodb.ScalarAttributeTypeS,
+			"sk":       dynamodb.ScalarAttributeTypeS,
+			"data":     dynamodb.ScalarAttributeTypeS,
+			"lsi_1_sk": dynamodb.ScalarAttributeTypeN,
+		},
+		GlobalSecondaryIndexes: []*IndexSchema{
+			{
+				Name:             "gsi_1",
+				PartitionKey:     "sk",
+				SortKey:          "data",
+				ProjectionType:   dynamodb.ProjectionTypeInclude,
+				NonKeyAttributes: append(projectedAttributes("picture", "photo"), expiresAtAttribute),
+			},
+			{
+				Name:           "gsi_2",
+				PartitionKey:   "data",
+				SortKey:        "sk",
+				ProjectionType: dynamodb.ProjectionTypeKeysOnly,
+			},
+		},
+		LocalSecondaryIndexes: []*IndexSchema{
+			{
+				Name:           "lsi_1",
+				PartitionKey:   "pk",
+				SortKey:        "lsi_1_sk",
+				ProjectionType: dynamodb.ProjectionTypeAll,
+			},
+		},
+		TimeToLiveAttribute: expiresAtAttribute,
+	}
+}
+
+// Index returns the key schema of the base table for an empty name or of the secondary index with the given name
+func (s *TableSchema) Index(name string) *IndexSchema {
+	if name == "" {
+		return &IndexSchema{
+			PartitionKey:   s.PartitionKey,
+			SortKey:        s.SortKey,
+			ProjectionType: dynamodb.ProjectionTypeAll,
+		}
+	}
+	for _, index := range s.GlobalSecondaryIndexes {
+		if index.Name == name {
+			return index
+		}
+	}
+	for _, index := range s.LocalSecondaryIndexes {
+		if index.Name == name {
+			return index
+		}
+	}
+	return nil
+}
+
+func (s *TableSchema) CreateTableInput(tableName string) (*dynamodb.CreateTableInput, error) {
+	keyAttributes := []string{s.PartitionKey, s.SortKey}
+
+	var globalSecondaryIndexes []*dynamodb.GlobalSecondaryIndex
+	for _, index := range s.GlobalSecondaryIndexes {
+		globalSecondaryIndexes = append(globalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:             aws.String(index.Name),
+			KeySchema:             keySchema(index.PartitionKey, index.SortKey),
+			Projection:            index.projection(),
+			ProvisionedThroughput: s.IndexThroughput(index),
+		})
+		keyAttributes = append(keyAttributes, index.PartitionKey, index.SortKey)
+	}
+
+	var localSecondaryIndexes []*dynamodb.LocalSecondaryIndex
+	for _, index := range s.LocalSecondaryIndexes {
+		if index.PartitionKey != s.PartitionKey {
+			return nil, fmt.Errorf("local secondary index %v must use the table partition key %v", index.Name, s.PartitionKey)
+		}
+		localSecondaryIndexes = append(localSecondaryIndexes, &dynamodb.LocalSecondaryIndex{
+			IndexName:  aws.String(index.Name),
+			KeySchema:  keySchema(index.PartitionKey, index.SortKey),
+			Projection: index.projection(),
+		})
+		keyAttributes = append(keyAttributes, index.SortKey)
+	}
+
+	attributeDefinitions, err := s.attributeDefinitions(keyAttributes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.CreateTableInput{
+		TableName:              aws.String(tableName),
+		KeySchema:              keySchema(s.PartitionKey, s.SortKey),
+		AttributeDefinitions:   attributeDefinitions,
+		GlobalSecondaryIndexes: globalSecondaryIndexes,
+		LocalSecondaryIndexes:  localSecondaryIndexes,
+		BillingMode:            aws.String(s.billingMode()),
+		ProvisionedThroughput:  s.TableThroughput(),
+		StreamSpecification:    s.streamSpecification(),
+	}, nil
+}
+
+// CreateIndexInput returns the UpdateTable request which adds the global secondary index with the given name to
+// an existing table
+func (s *TableSchema) CreateIndexInput(tableName string, indexName string) (*dynamodb.UpdateTableInput, error) {
+	var index *IndexSchema
+	for _, globalSecondaryIndex := range s.GlobalSecondaryIndexes {
+		if globalSecondaryIndex.Name == indexName {
+			index = globalSecondaryIndex
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("global secondary index %v is not defined in the table schema", indexName)
+	}
+
+	attributeDefinitions, err := s.attributeDefinitions(index.PartitionKey, index.SortKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.UpdateTableInput{
+		TableName:            aws.String(tableName),
+		AttributeDefinitions: attributeDefinitions,
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+			{
+				Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName:             aws.String(index.Name),
+					KeySchema:             keySchema(index.PartitionKey, index.SortKey),
+					Projection:            index.projection(),
+					ProvisionedThroughput: s.IndexThroughput(index),
+				},
+			},
+		},
+	}, nil
+}
+
+// TableThroughput returns the provisioned throughput of the table, nil in on-demand mode
+func (s *TableSchema) TableThroughput() *dynamodb.ProvisionedThroughput {
+	if s.BillingMode != dynamodb.BillingModeProvisioned {
+		return nil
+	}
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(s.ReadCapacity),
+		WriteCapacityUnits: aws.Int64(s.WriteCapacity),
+	}
+}
+
+// IndexThroughput returns the provisioned throughput of a global secondary index, nil in on-demand mode
+func (s *TableSchema) IndexThroughput(index *IndexSchema) *dynamodb.ProvisionedThroughput {
+	if s.BillingMode != dynamodb.BillingModeProvisioned {
+		return nil
+	}
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(s.ReadCapacity),
+		WriteCapacityUnits: aws.Int64(s.WriteCapacity),
+	}
+	if index.ReadCapacity > 0 {
+		throughput.ReadCapacityUnits = aws.Int64(index.ReadCapacity)
+	}
+	if index.WriteCapacity > 0 {
+		throughput.WriteCapacityUnits = aws.Int64(index.WriteCapacity)
+	}
+	return throughput
+}
+
+// attributeDefinitions returns the definitions of the given key attributes, each attribute once
+func (s *TableSchema) attributeDefinitions(attributeNames ...string) ([]*dynamodb.AttributeDefinition, error) {
+	var attributeDefinitions []*dynamodb.AttributeDefinition
+	defined := map[string]bool{}
+	for _, attributeName := range attributeNames {
+		if attributeName == "" || defined[attributeName] {
+			continue
+		}
+		attributeType, ok := s.Attributes[attributeName]
+		if !ok {
+			return nil, fmt.Errorf("key attribute %v is not defined in the table schema", attributeName)
+		}
+		attributeDefinitions = append(attributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(attributeName),
+			AttributeType: aws.String(attributeType),
+		})
+		defined[attributeName] = true
+	}
+	return attributeDefinitions, nil
+}
+
+// billingMode returns the billing mode new tables are created with
+func (s *TableSchema) billingMode() string {
+	if s.BillingMode == "" {
+		return dynamodb.BillingModePayPerRequest
+	}
+	return s.BillingMode
+}
+
+func (s *TableSchema) streamSpecification() *dynamodb.StreamSpecification {
+	if s.StreamViewType == "" {
+		return nil
+	}
+	return &dynamodb.StreamSpecification{
+		StreamEnabled:  aws.Bool(true),
+		StreamViewType: aws.String(s.StreamViewType),
+	}
+}
+
+func (i *IndexSchema) projection() *dynamodb.Projection {
+	projection := &dynamodb.Projection{
+		ProjectionType: aws.String(i.ProjectionType),
+	}
+	if len(i.NonKeyAttributes) > 0 {
+		projection.NonKeyAttributes = aws.StringSlice(i.NonKeyAttributes)
+	}
+	return projection
+}
+
+func keySchema(partitionKey string, sortKey string) []*dynamodb.KeySchemaElement {
+	elements := []*dynamodb.KeySchemaElement{
+		{
+			AttributeName: aws.String(partitionKey),
+			KeyType:       aws.String(dynamodb.KeyTypeHash),
+		},
+	}
+	if sortKey != "" {
+		elements = append(elements, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(sortKey),
+			KeyType:       aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+	return elements
+}
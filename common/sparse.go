@@ -0,0 +1,199 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const sparseIndexPrefix = "sparse"
+
+// SparseIndex declares a subset of an entity type that can be listed without a Scan.
+//
+// For every item of EntityType that satisfies Predicate the repository maintains an index entry next to the
+// item (same pk, sk=sparse#<Name>, data=<item pk>). The entry carries a copy of the attributes of the entity, so the
+// gsi_1 partition sk=sparse#<Name> contains exactly the members of the index and nothing else.
+type SparseIndex struct {
+	Name       string
+	EntityType string
+	Predicate  func(item map[string]*dynamodb.AttributeValue) bool
+}
+
+func DefaultSparseIndexes() []*SparseIndex {
+	return []*SparseIndex{
+		{
+			Name:       "discontinued-products",
+			EntityType: productPrefix,
+			Predicate: func(item map[string]*dynamodb.AttributeValue) bool {
+				return boolAttribute(item, "discontinued")
+			},
+		},
+		{
+			Name:       "customers-without-fax",
+			EntityType: customerPrefix,
+			Predicate: func(item map[string]*dynamodb.AttributeValue) bool {
+				return isEmptyValue(stringAttribute(item, "fax"))
+			},
+		},
+		{
+			Name:       "orders-awaiting-shipment",
+			EntityType: orderPrefix,
+			Predicate: func(item map[string]*dynamodb.AttributeValue) bool {
+				return isEmptyValue(stringAttribute(item, "shippedDate"))
+			},
+		},
+	}
+}
+
+func (r *Repository) RegisterSparseIndex(index *SparseIndex) error {
+	if index.Name == "" || index.EntityType == "" || index.Predicate == nil {
+		return fmt.Errorf("sparse index requires a name, an entity type and a predicate")
+	}
+	if r.sparseIndex(index.Name) != nil {
+		return fmt.Errorf("sparse index %v is already registered", index.Name)
+	}
+
+	r.sparseIndexes = append(r.sparseIndexes, index)
+	return nil
+}
+
+// List all items of a sparse index
+// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#discontinued-products'))
+func (r *Repository) ListSparse(indexName string) ([]map[string]*dynamodb.AttributeValue, error) {
+	index := r.sparseIndex(indexName)
+	if index == nil {
+		return nil, fmt.Errorf("unknown sparse index %v", indexName)
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	err := r.dynamoDBClient.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String("gsi_1"),
+		KeyConditionExpression: aws.String("sk=:sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {
+				S: aws.String(sparseIndexKey(indexName)),
+			},
+		},
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		items = append(items, output.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sparse index %v from dynamodb: %v", indexName, err)
+	}
+
+	return r.completeItems("gsi_1", index.EntityType, items)
+}
+
+func (r *Repository) sparseIndex(name string) *SparseIndex {
+	for _, index := range r.sparseIndexes {
+		if index.Name == name {
+			return index
+		}
+	}
+	return nil
+}
+
+// sparseIndexWrites returns the writes which bring the index entries of an item in line with its attributes:
+// entries are put for every index whose predicate holds and deleted for every other index of the entity type for
+// which wasMember reports that the item may have been a member before.
+func (r *Repository) sparseIndexWrites(entityType string, item map[string]*dynamodb.AttributeValue, wasMember func(index *SparseIndex) bool) []*dynamodb.TransactWriteItem {
+	var writes []*dynamodb.TransactWriteItem
+	for _, index := range r.sparseIndexes {
+		if index.EntityType != entityType {
+			continue
+		}
+
+		if !index.Predicate(item) {
+			if wasMember(index) {
+				writes = append(writes, &dynamodb.TransactWriteItem{
+					Delete: &dynamodb.Delete{
+						TableName: aws.String(r.tableName),
+						Key:       sparseIndexEntryKey(index.Name, item["pk"]),
+					},
+				})
+			}
+			continue
+		}
+
+		writes = append(writes, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: aws.String(r.tableName),
+				Item:      sparseIndexEntry(index, item),
+			},
+		})
+	}
+	return writes
+}
+
+// sparseIndexEntry returns the index entry of an item with the attributes of its entity type, which the list queries
+// read, and without the keys of the item in other indexes
+func sparseIndexEntry(index *SparseIndex, item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	entry := map[string]*dynamodb.AttributeValue{}
+	if record, ok := entityRecords[index.EntityType]; ok {
+		for _, name := range append(recordAttributes(record), expiresAtAttribute) {
+			if value, ok := item[name]; ok {
+				entry[name] = value
+			}
+		}
+	} else {
+		for name, value := range item {
+			entry[name] = value
+		}
+	}
+	entry["pk"] = item["pk"]
+	entry["sk"] = &dynamodb.AttributeValue{
+		S: aws.String(sparseIndexKey(index.Name)),
+	}
+	entry["data"] = item["pk"]
+	return entry
+}
+
+// sparseIndexDeletes returns the deletes for all index entries of the item with the given pk.
+func (r *Repository) sparseIndexDeletes(entityType string, pk *dynamodb.AttributeValue) []*dynamodb.TransactWriteItem {
+	var writes []*dynamodb.TransactWriteItem
+	for _, index := range r.sparseIndexes {
+		if index.EntityType != entityType {
+			continue
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(r.tableName),
+				Key:       sparseIndexEntryKey(index.Name, pk),
+			},
+		})
+	}
+	return writes
+}
+
+func sparseIndexKey(indexName string) string {
+	return fmt.Sprintf("%s#%s", sparseIndexPrefix, indexName)
+}
+
+func sparseIndexEntryKey(indexName string, pk *dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": pk,
+		"sk": {
+			S: aws.String(sparseIndexKey(indexName)),
+		},
+	}
+}
+
+func stringAttribute(item map[string]*dynamodb.AttributeValue, name string) string {
+	value, ok := item[name]
+	if !ok || value.S == nil {
+		return ""
+	}
+	return *value.S
+}
+
+func boolAttribute(item map[string]*dynamodb.AttributeValue, name string) bool {
+	value, ok := item[name]
+	return ok && aws.BoolValue(value.BOOL)
+}
+
+// Missing values are absent, NULL or, in tables loaded before null tokens were handled, the literal string NULL
+func isEmptyValue(value string) bool {
+	return value == "" || value == nullToken
+}
@@ -7,69 +7,52 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	tableStatusPollInterval = 5 * time.Second
+	defaultIndexTimeout     = 24 * time.Hour
+	defaultRestoreTimeout   = 24 * time.Hour
 )
 
 type TableManager struct {
 	dynamoDBClient dynamodbiface.DynamoDBAPI
 	tableName      string
+	schema         *TableSchema
+	indexTimeout   time.Duration
+	restoreTimeout time.Duration
 }
 
-func NewTableManager(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string) *TableManager {
+func NewTableManager(dynamoDBClient dynamodbiface.DynamoDBAPI, tableName string, schema *TableSchema) *TableManager {
 	return &TableManager{
 		dynamoDBClient: dynamoDBClient,
 		tableName:      tableName,
+		schema:         schema,
+		indexTimeout:   defaultIndexTimeout,
+		restoreTimeout: defaultRestoreTimeout,
 	}
 }
 
+// SetIndexTimeout sets how long AddIndex and RemoveIndex wait for the index to be created or removed
+func (r *TableManager) SetIndexTimeout(timeout time.Duration) {
+	r.indexTimeout = timeout
+}
+
+// SetRestoreTimeout sets how long RestoreBackup and RestorePointInTime wait for the restored table to be active
+func (r *TableManager) SetRestoreTimeout(timeout time.Duration) {
+	r.restoreTimeout = timeout
+}
+
 func (r *TableManager) CreateTable() error {
 	log.WithField("table", r.tableName).Info("Creating the dynamoDB table")
 
-	_, err := r.dynamoDBClient.CreateTable(&dynamodb.CreateTableInput{
-		TableName: aws.String(r.tableName),
-		KeySchema: []*dynamodb.KeySchemaElement{
-			{
-				AttributeName: aws.String("pk"),
-				KeyType:       aws.String(dynamodb.KeyTypeHash),
-			},
-			{
-				AttributeName: aws.String("sk"),
-				KeyType:       aws.String(dynamodb.KeyTypeRange),
-			},
-		},
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
-			{
-				AttributeName: aws.String("pk"),
-				AttributeType: aws.String("S"),
-			},
-			{
-				AttributeName: aws.String("sk"),
-				AttributeType: aws.String("S"),
-			},
-			{
-				AttributeName: aws.String("data"),
-				AttributeType: aws.String("S"),
-			},
-		},
-		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
-			{
-				IndexName: aws.String("gsi_1"),
-				KeySchema: []*dynamodb.KeySchemaElement{
-					{
-						AttributeName: aws.String("sk"),
-						KeyType:       aws.String(dynamodb.KeyTypeHash),
-					},
-					{
-						AttributeName: aws.String("data"),
-						KeyType:       aws.String(dynamodb.KeyTypeRange),
-					},
-				},
-				Projection: &dynamodb.Projection{
-					ProjectionType: aws.String(dynamodb.ProjectionTypeAll),
-				},
-			},
-		},
-		BillingMode: aws.String("PAY_PER_REQUEST"),
-	})
+	createTableInput, err := r.schema.CreateTableInput(r.tableName)
+	if err != nil {
+		return fmt.Errorf("invalid schema for dynamoDB table %v: %v", r.tableName, err)
+	}
+
+	_, err = r.dynamoDBClient.CreateTable(createTableInput)
 	if err != nil {
 		return fmt.Errorf("could not create dynamoDB table %v: %v", r.tableName, err)
 	}
@@ -81,83 +64,346 @@ func (r *TableManager) CreateTable() error {
 		return fmt.Errorf("error waiting for dynamoDB table %v creation: %v", r.tableName, err)
 	}
 
+	if r.schema.TimeToLiveAttribute != "" {
+		err = r.EnableTTL()
+		if err != nil {
+			return err
+		}
+	}
+
 	log.WithField("table", r.tableName).Info("Created table")
 
 	return nil
 }
 
-func (r *TableManager) DeleteTable() error {
-	log.WithField("table", r.tableName).Info("Deleting the dynamoDB table")
+// UpdateTable applies an UpdateTable request and waits until the table and all of its indexes are active again
+func (r *TableManager) UpdateTable(input *dynamodb.UpdateTableInput) error {
+	log.WithField("table", r.tableName).Info("Updating the dynamoDB table")
 
-	_, err := r.dynamoDBClient.DeleteTable(&dynamodb.DeleteTableInput{
+	_, err := r.dynamoDBClient.UpdateTable(input)
+	if err != nil {
+		return fmt.Errorf("could not update dynamoDB table %v: %v", r.tableName, err)
+	}
+
+	err = r.WaitUntilActive()
+	if err != nil {
+		return err
+	}
+
+	log.WithField("table", r.tableName).Info("Updated table")
+
+	return nil
+}
+
+// SetCapacity switches the table to the billing mode of the schema and applies the provisioned throughput of the
+// schema to the table and all of its global secondary indexes. Only the settings which differ are updated, as
+// DynamoDB rejects updates which change nothing.
+func (r *TableManager) SetCapacity() error {
+	if r.schema.BillingMode == "" {
+		return fmt.Errorf("table schema has no billing mode")
+	}
+
+	table, err := r.describeTable()
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateTableInput{
 		TableName: aws.String(r.tableName),
+	}
+	billingMode := dynamodb.BillingModeProvisioned
+	if table.BillingModeSummary != nil {
+		billingMode = aws.StringValue(table.BillingModeSummary.BillingMode)
+	}
+	if billingMode != r.schema.BillingMode {
+		input.BillingMode = aws.String(r.schema.BillingMode)
+	}
+	if throughput := r.schema.TableThroughput(); throughput != nil && formatThroughput(throughput) != formatThroughputDescription(table.ProvisionedThroughput) {
+		input.ProvisionedThroughput = throughput
+	}
+
+	for _, index := range table.GlobalSecondaryIndexes {
+		indexSchema := r.schema.Index(aws.StringValue(index.IndexName))
+		if indexSchema == nil {
+			indexSchema = &IndexSchema{}
+		}
+		throughput := r.schema.IndexThroughput(indexSchema)
+		if throughput == nil || formatThroughput(throughput) == formatThroughputDescription(index.ProvisionedThroughput) {
+			continue
+		}
+		input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, &dynamodb.GlobalSecondaryIndexUpdate{
+			Update: &dynamodb.UpdateGlobalSecondaryIndexAction{
+				IndexName:             index.IndexName,
+				ProvisionedThroughput: throughput,
+			},
+		})
+	}
+
+	if input.BillingMode == nil && input.ProvisionedThroughput == nil && len(input.GlobalSecondaryIndexUpdates) == 0 {
+		log.WithField("table", r.tableName).Info("Capacity already set")
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"table":          r.tableName,
+		"billing_mode":   r.schema.BillingMode,
+		"read_capacity":  r.schema.ReadCapacity,
+		"write_capacity": r.schema.WriteCapacity,
+	}).Info("Setting the capacity of the dynamoDB table")
+
+	return r.UpdateTable(input)
+}
+
+// EnableTTL lets DynamoDB delete items after the epoch in the time to live attribute of the schema
+func (r *TableManager) EnableTTL() error {
+	if r.schema.TimeToLiveAttribute == "" {
+		return fmt.Errorf("table schema has no time to live attribute")
+	}
+
+	description, err := r.DescribeTTL()
+	if err != nil {
+		return err
+	}
+	if formatTTL(description) == fmt.Sprintf("%v on %v", dynamodb.TimeToLiveStatusEnabled, r.schema.TimeToLiveAttribute) {
+		log.WithField("table", r.tableName).Info("Time to live already enabled")
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"table":     r.tableName,
+		"attribute": r.schema.TimeToLiveAttribute,
+	}).Info("Enabling time to live of the dynamoDB table")
+
+	_, err = r.dynamoDBClient.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(r.tableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(r.schema.TimeToLiveAttribute),
+			Enabled:       aws.Bool(true),
+		},
 	})
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
-			// Table already deleted -> do nothing
-			log.WithField("table", r.tableName).Info("Table already deleted")
+		return fmt.Errorf("could not enable time to live of dynamoDB table %v: %v", r.tableName, err)
+	}
+
+	return nil
+}
+
+func (r *TableManager) DescribeTTL() (*dynamodb.TimeToLiveDescription, error) {
+	output, err := r.dynamoDBClient.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe time to live of dynamoDB table %v: %v", r.tableName, err)
+	}
+	return output.TimeToLiveDescription, nil
+}
+
+// WaitUntilActive polls the table until the table and all of its global secondary indexes are ACTIVE
+func (r *TableManager) WaitUntilActive() error {
+	for {
+		table, err := r.describeTable()
+		if err != nil {
+			return err
+		}
+
+		active := aws.StringValue(table.TableStatus) == dynamodb.TableStatusActive
+		for _, index := range table.GlobalSecondaryIndexes {
+			if aws.StringValue(index.IndexStatus) != dynamodb.IndexStatusActive {
+				active = false
+			}
+		}
+		if active {
 			return nil
 		}
-		return fmt.Errorf("could not delete dynamoDB table %v: %v", r.tableName, err)
+
+		time.Sleep(tableStatusPollInterval)
 	}
+}
 
-	err = r.dynamoDBClient.WaitUntilTableNotExists(&dynamodb.DescribeTableInput{
-		TableName: aws.String(r.tableName),
+// AddIndex creates the global secondary index with the given name as defined in the table schema and waits until
+// DynamoDB finished backfilling it, at most the index timeout
+func (r *TableManager) AddIndex(indexName string) error {
+	logger := log.WithFields(log.Fields{
+		"table": r.tableName,
+		"index": indexName,
 	})
+
+	table, err := r.describeTable()
 	if err != nil {
-		return fmt.Errorf("error waiting for dynamoDB table %v deletion: %v", r.tableName, err)
+		return err
+	}
+	if findIndex(table, indexName) != nil {
+		logger.Info("Index already exists")
+		return nil
+	}
+	err = checkNoIndexCreating(table)
+	if err != nil {
+		return err
 	}
 
-	log.WithField("table", r.tableName).Info("Deleted table")
+	input, err := r.schema.CreateIndexInput(r.tableName, indexName)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Adding index to the dynamoDB table")
+
+	_, err = r.dynamoDBClient.UpdateTable(input)
+	if err != nil {
+		return fmt.Errorf("could not add index %v to dynamoDB table %v: %v", indexName, r.tableName, err)
+	}
+
+	started := time.Now()
+	for {
+		time.Sleep(tableStatusPollInterval)
+
+		table, err := r.describeTable()
+		if err != nil {
+			return err
+		}
+		index := findIndex(table, indexName)
+		if index == nil {
+			return fmt.Errorf("index %v of dynamoDB table %v disappeared while it was created", indexName, r.tableName)
+		}
+
+		// DynamoDB refreshes the item counts about every six hours, so they are an indication of progress only
+		logger.WithFields(log.Fields{
+			"status":      aws.StringValue(index.IndexStatus),
+			"backfilling": aws.BoolValue(index.Backfilling),
+			"elapsed":     time.Since(started).Round(time.Second).String(),
+			"index_items": aws.Int64Value(index.ItemCount),
+			"table_items": aws.Int64Value(table.ItemCount),
+		}).Info("Waiting for index")
+
+		if aws.StringValue(index.IndexStatus) == dynamodb.IndexStatusActive {
+			break
+		}
+		if time.Since(started) >= r.indexTimeout {
+			return fmt.Errorf("index %v of dynamoDB table %v is not active after %v", indexName, r.tableName, r.indexTimeout)
+		}
+	}
+
+	logger.Info("Added index")
 
 	return nil
 }
 
-func (r *TableManager) PurgeTable() error {
-	log.WithField("table", r.tableName).Info("Purging the dynamoDB table")
+// RemoveIndex deletes the global secondary index with the given name and waits until it is gone, at most the index
+// timeout
+func (r *TableManager) RemoveIndex(indexName string) error {
+	logger := log.WithFields(log.Fields{
+		"table": r.tableName,
+		"index": indexName,
+	})
 
-	err := r.dynamoDBClient.ScanPages(&dynamodb.ScanInput{
+	table, err := r.describeTable()
+	if err != nil {
+		return err
+	}
+	if findIndex(table, indexName) == nil {
+		logger.Info("Index already removed")
+		return nil
+	}
+	err = checkNoIndexCreating(table)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Removing index from the dynamoDB table")
+
+	_, err = r.dynamoDBClient.UpdateTable(&dynamodb.UpdateTableInput{
 		TableName: aws.String(r.tableName),
-		ProjectionExpression:aws.String("pk, sk"),
-		Limit: aws.Int64(25),
-
-	}, func(output *dynamodb.ScanOutput, b bool) bool {
-		if len(output.Items) == 0 {
-			return true
-		}
-
-		var writeRequests []*dynamodb.WriteRequest
-		for _, item := range output.Items {
-			writeRequests = append(writeRequests, &dynamodb.WriteRequest{
-				DeleteRequest: &dynamodb.DeleteRequest{
-					Key: map[string]*dynamodb.AttributeValue{
-						"sk": {
-							S: item["sk"].S,
-						},
-						"pk": {
-							S: item["pk"].S,
-						},
-					},
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+			{
+				Delete: &dynamodb.DeleteGlobalSecondaryIndexAction{
+					IndexName: aws.String(indexName),
 				},
-			})
-		}
-
-		_, err := r.dynamoDBClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]*dynamodb.WriteRequest{
-				r.tableName: writeRequests,
 			},
-		})
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not remove index %v from dynamoDB table %v: %v", indexName, r.tableName, err)
+	}
+
+	started := time.Now()
+	for {
+		time.Sleep(tableStatusPollInterval)
+
+		table, err := r.describeTable()
 		if err != nil {
-			log.WithError(err).Errorf("Error deleting items in batchWriteItem request")
+			return err
+		}
+		index := findIndex(table, indexName)
+		if index == nil {
+			break
 		}
+		logger.WithFields(log.Fields{
+			"status":  aws.StringValue(index.IndexStatus),
+			"elapsed": time.Since(started).Round(time.Second).String(),
+		}).Info("Waiting for index removal")
+
+		if time.Since(started) >= r.indexTimeout {
+			return fmt.Errorf("index %v of dynamoDB table %v is not removed after %v", indexName, r.tableName, r.indexTimeout)
+		}
+	}
 
-		return true
+	logger.Info("Removed index")
+
+	return nil
+}
+
+// DynamoDB allows only one index creation at a time
+func checkNoIndexCreating(table *dynamodb.TableDescription) error {
+	for _, index := range table.GlobalSecondaryIndexes {
+		if aws.StringValue(index.IndexStatus) == dynamodb.IndexStatusCreating || aws.BoolValue(index.Backfilling) {
+			return fmt.Errorf("index %v of dynamoDB table %v is still being created", aws.StringValue(index.IndexName), aws.StringValue(table.TableName))
+		}
+	}
+	return nil
+}
+
+func findIndex(table *dynamodb.TableDescription, indexName string) *dynamodb.GlobalSecondaryIndexDescription {
+	for _, index := range table.GlobalSecondaryIndexes {
+		if aws.StringValue(index.IndexName) == indexName {
+			return index
+		}
+	}
+	return nil
+}
+
+func (r *TableManager) describeTable() (*dynamodb.TableDescription, error) {
+	output, err := r.dynamoDBClient.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(r.tableName),
 	})
 	if err != nil {
-		return fmt.Errorf("error scanning dynamoDB table %v: %v", r.tableName, err)
+		return nil, fmt.Errorf("could not describe dynamoDB table %v: %v", r.tableName, err)
 	}
+	return output.Table, nil
+}
 
-	log.WithField("table", r.tableName).Info("Purged table")
+func (r *TableManager) DeleteTable() error {
+	log.WithField("table", r.tableName).Info("Deleting the dynamoDB table")
+
+	_, err := r.dynamoDBClient.DeleteTable(&dynamodb.DeleteTableInput{
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			// Table already deleted -> do nothing
+			log.WithField("table", r.tableName).Info("Table already deleted")
+			return nil
+		}
+		return fmt.Errorf("could not delete dynamoDB table %v: %v", r.tableName, err)
+	}
+
+	err = r.dynamoDBClient.WaitUntilTableNotExists(&dynamodb.DescribeTableInput{
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for dynamoDB table %v deletion: %v", r.tableName, err)
+	}
+
+	log.WithField("table", r.tableName).Info("Deleted table")
 
 	return nil
 }
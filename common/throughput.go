@@ -0,0 +1,180 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+type ThroughputLimits struct {
+	MinRead  int64
+	MaxRead  int64
+	MinWrite int64
+	MaxWrite int64
+}
+
+// ThroughputControllerConfig configures the throughput controller. Indexes without own limits use the limits of
+// the table.
+type ThroughputControllerConfig struct {
+	TargetUtilization float64
+	Interval          time.Duration
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+	Table             ThroughputLimits
+	Indexes           map[string]ThroughputLimits
+}
+
+// ThroughputController adjusts the provisioned throughput of the table and its global secondary indexes to the
+// capacity consumed by a repository, similar to Application Auto Scaling target tracking.
+type ThroughputController struct {
+	tableManager     *TableManager
+	consumedCapacity *ConsumedCapacity
+	config           ThroughputControllerConfig
+	lastScaleUp      time.Time
+	lastScaleDown    time.Time
+}
+
+func NewThroughputController(tableManager *TableManager, consumedCapacity *ConsumedCapacity, config ThroughputControllerConfig) *ThroughputController {
+	return &ThroughputController{
+		tableManager:     tableManager,
+		consumedCapacity: consumedCapacity,
+		config:           config,
+	}
+}
+
+// Run adjusts the throughput every configured interval until stop is closed
+func (c *ThroughputController) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := c.Adjust(now.Sub(last))
+			if err != nil {
+				log.WithError(err).WithField("table", c.tableManager.tableName).Error("Could not adjust throughput")
+			}
+			last = now
+		}
+	}
+}
+
+// Adjust compares the capacity consumed within the elapsed time with the provisioned throughput and updates the
+// table if the throughput is outside of the target utilization and the cooldown has passed
+func (c *ThroughputController) Adjust(elapsed time.Duration) error {
+	usage := c.consumedCapacity.Drain()
+
+	table, err := c.tableManager.describeTable()
+	if err != nil {
+		return err
+	}
+	if table.BillingModeSummary != nil && aws.StringValue(table.BillingModeSummary.BillingMode) == dynamodb.BillingModePayPerRequest {
+		return nil
+	}
+	if aws.StringValue(table.TableStatus) != dynamodb.TableStatusActive {
+		return nil
+	}
+
+	now := time.Now()
+	input := &dynamodb.UpdateTableInput{
+		TableName: aws.String(c.tableManager.tableName),
+	}
+	// Every scale up and scale down waits for its own cooldown, also when the table and an index change together
+	canScaleUp := now.Sub(c.lastScaleUp) >= c.config.ScaleUpCooldown
+	canScaleDown := now.Sub(c.lastScaleDown) >= c.config.ScaleDownCooldown
+	scaleUp, scaleDown := false, false
+
+	desired, up, down := c.desiredThroughput(table.ProvisionedThroughput, usage[""], elapsed, c.config.Table)
+	if (up && canScaleUp) || (down && canScaleDown) {
+		input.ProvisionedThroughput = desired
+		scaleUp, scaleDown = scaleUp || up, scaleDown || down
+	}
+
+	for _, index := range table.GlobalSecondaryIndexes {
+		limits, ok := c.config.Indexes[aws.StringValue(index.IndexName)]
+		if !ok {
+			limits = c.config.Table
+		}
+		desired, up, down := c.desiredThroughput(index.ProvisionedThroughput, usage[aws.StringValue(index.IndexName)], elapsed, limits)
+		if (up && canScaleUp) || (down && canScaleDown) {
+			input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, &dynamodb.GlobalSecondaryIndexUpdate{
+				Update: &dynamodb.UpdateGlobalSecondaryIndexAction{
+					IndexName:             index.IndexName,
+					ProvisionedThroughput: desired,
+				},
+			})
+			scaleUp, scaleDown = scaleUp || up, scaleDown || down
+		}
+	}
+
+	if !scaleUp && !scaleDown {
+		return nil
+	}
+
+	err = c.tableManager.UpdateTable(input)
+	if err != nil {
+		return fmt.Errorf("could not adjust throughput: %v", err)
+	}
+	if scaleUp {
+		c.lastScaleUp = now
+	}
+	if scaleDown {
+		c.lastScaleDown = now
+	}
+
+	return nil
+}
+
+// desiredThroughput returns the throughput which serves the consumed capacity at the target utilization within the
+// limits, and whether this is a scale up or a scale down of the current throughput. A scale up keeps the other
+// dimension at least at its current throughput, so that decreases are subject to the scale down cooldown.
+func (c *ThroughputController) desiredThroughput(current *dynamodb.ProvisionedThroughputDescription, usage CapacityUsage, elapsed time.Duration, limits ThroughputLimits) (*dynamodb.ProvisionedThroughput, bool, bool) {
+	if current == nil || elapsed <= 0 {
+		return nil, false, false
+	}
+
+	currentRead, currentWrite := aws.Int64Value(current.ReadCapacityUnits), aws.Int64Value(current.WriteCapacityUnits)
+	read := c.desiredUnits(usage.Read/elapsed.Seconds(), limits.MinRead, limits.MaxRead)
+	write := c.desiredUnits(usage.Write/elapsed.Seconds(), limits.MinWrite, limits.MaxWrite)
+
+	up := read > currentRead || write > currentWrite
+	down := !up && (read < currentRead || write < currentWrite)
+	if !up && !down {
+		return nil, false, false
+	}
+	if up {
+		read, write = maxInt64(read, currentRead), maxInt64(write, currentWrite)
+	}
+
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(read),
+		WriteCapacityUnits: aws.Int64(write),
+	}, up, down
+}
+
+func (c *ThroughputController) desiredUnits(unitsPerSecond float64, min int64, max int64) int64 {
+	units := int64(math.Ceil(unitsPerSecond / c.config.TargetUtilization))
+	if units < min {
+		units = min
+	}
+	if max > 0 && units > max {
+		units = max
+	}
+	if units < 1 {
+		units = 1
+	}
+	return units
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// KeyTransformation rewrites an item of one key layout into another one while copying a table. Transform returns
+// nil to skip an item.
+type KeyTransformation struct {
+	Name        string
+	Description string
+	Transform   func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error)
+}
+
+// KeyTransformations returns all key transformations available to copy-table
+func KeyTransformations() []*KeyTransformation {
+	return []*KeyTransformation{
+		{
+			Name:        "v1-to-v2",
+			Description: "Fix the customer pk and prefix the sk of customers and shippers, as the migrations 0001 and 0002 do",
+			Transform: func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+				pk, sk := aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S)
+				if fixedPk, ok := fixedCustomerPk(pk); ok {
+					if aws.StringValue(item["data"].S) == pk {
+						item["data"] = &dynamodb.AttributeValue{S: aws.String(fixedPk)}
+					}
+					pk = fixedPk
+				}
+				if prefixedSk, ok := prefixedSortKey(pk, sk); ok {
+					sk = prefixedSk
+				}
+				return withKey(item, pk, sk), nil
+			},
+		},
+		{
+			Name:        "v2-to-v1",
+			Description: "Remove the entity type prefix from the sk of customers and shippers",
+			Transform: func(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+				pk, sk := aws.StringValue(item["pk"].S), aws.StringValue(item["sk"].S)
+				if unprefixedSk, ok := unprefixedSortKey(pk, sk); ok {
+					sk = unprefixedSk
+				}
+				return withKey(item, pk, sk), nil
+			},
+		},
+	}
+}
+
+func KeyTransformationByName(name string) *KeyTransformation {
+	for _, transformation := range KeyTransformations() {
+		if transformation.Name == name {
+			return transformation
+		}
+	}
+	return nil
+}
+
+func withKey(item map[string]*dynamodb.AttributeValue, pk string, sk string) map[string]*dynamodb.AttributeValue {
+	item["pk"] = &dynamodb.AttributeValue{S: aws.String(pk)}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(sk)}
+	return item
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+const expiresAtAttribute = "expiresAt"
+
+// DefaultEntityTTLs returns the time to live of the entity types which expire
+func DefaultEntityTTLs() map[string]time.Duration {
+	return map[string]time.Duration{
+		idempotencyPrefix: 24 * time.Hour,
+	}
+}
+
+// RegisterEntityTTL lets items of an entity type expire the given duration after they were stored
+func (r *Repository) RegisterEntityTTL(entityType string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("time to live of entity type %v must be positive", entityType)
+	}
+	r.entityTTLs[entityType] = ttl
+	return nil
+}
+
+// setExpiry sets the expiresAt epoch attribute on items of expiring entity types
+func (r *Repository) setExpiry(entityType string, item map[string]*dynamodb.AttributeValue) {
+	ttl, ok := r.entityTTLs[entityType]
+	if !ok {
+		return
+	}
+	item[expiresAtAttribute] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)),
+	}
+}
+
+// unexpiredItems drops expired items. DynamoDB deletes expired items only within days after their expiry.
+func (r *Repository) unexpiredItems(items []map[string]*dynamodb.AttributeValue) []map[string]*dynamodb.AttributeValue {
+	now := time.Now().Unix()
+
+	var unexpired []map[string]*dynamodb.AttributeValue
+	for _, item := range items {
+		if expiresAt, ok := item[expiresAtAttribute]; ok && expiresAt.N != nil {
+			epoch, err := strconv.ParseInt(*expiresAt.N, 10, 64)
+			if err == nil && epoch <= now {
+				continue
+			}
+		}
+		unexpired = append(unexpired, item)
+	}
+	return unexpired
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"sort"
+	"strings"
+)
+
+const absent = "absent"
+
+// TableDrift is a setting of the table which differs from the table schema
+type TableDrift struct {
+	Setting  string
+	Expected string
+	Actual   string
+}
+
+func (d TableDrift) String() string {
+	return fmt.Sprintf("%v: expected %v, actual %v", d.Setting, d.Expected, d.Actual)
+}
+
+// Verify compares the table with the table CreateTable would create from the schema and returns the differences
+func (r *TableManager) Verify() ([]TableDrift, error) {
+	expected, err := r.schema.CreateTableInput(r.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema for dynamoDB table %v: %v", r.tableName, err)
+	}
+	table, err := r.describeTable()
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := r.DescribeTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []TableDrift
+	compare := func(setting string, expected string, actual string) {
+		if expected != actual {
+			drifts = append(drifts, TableDrift{Setting: setting, Expected: expected, Actual: actual})
+		}
+	}
+
+	compare("key schema", formatKeySchema(expected.KeySchema), formatKeySchema(table.KeySchema))
+	compare("attribute definitions", formatAttributeDefinitions(expected.AttributeDefinitions), formatAttributeDefinitions(table.AttributeDefinitions))
+
+	// Without a billing mode in the schema the billing mode and throughput of the table are not compared
+	billingMode := dynamodb.BillingModeProvisioned
+	if table.BillingModeSummary != nil {
+		billingMode = aws.StringValue(table.BillingModeSummary.BillingMode)
+	}
+	if r.schema.BillingMode != "" {
+		compare("billing mode", r.schema.BillingMode, billingMode)
+	}
+	if expected.ProvisionedThroughput != nil {
+		compare("provisioned throughput", formatThroughput(expected.ProvisionedThroughput), formatThroughputDescription(table.ProvisionedThroughput))
+	}
+
+	actualGlobalIndexes := map[string]*dynamodb.GlobalSecondaryIndexDescription{}
+	for _, index := range table.GlobalSecondaryIndexes {
+		actualGlobalIndexes[aws.StringValue(index.IndexName)] = index
+	}
+	for _, index := range expected.GlobalSecondaryIndexes {
+		name := aws.StringValue(index.IndexName)
+		actual, ok := actualGlobalIndexes[name]
+		delete(actualGlobalIndexes, name)
+		if !ok {
+			compare(fmt.Sprintf("index %v", name), "present", absent)
+			continue
+		}
+		compare(fmt.Sprintf("index %v key schema", name), formatKeySchema(index.KeySchema), formatKeySchema(actual.KeySchema))
+		compare(fmt.Sprintf("index %v projection", name), formatProjection(index.Projection), formatProjection(actual.Projection))
+		if index.ProvisionedThroughput != nil {
+			compare(fmt.Sprintf("index %v provisioned throughput", name), formatThroughput(index.ProvisionedThroughput), formatThroughputDescription(actual.ProvisionedThroughput))
+		}
+	}
+	for name := range actualGlobalIndexes {
+		compare(fmt.Sprintf("index %v", name), absent, "present")
+	}
+
+	actualLocalIndexes := map[string]*dynamodb.LocalSecondaryIndexDescription{}
+	for _, index := range table.LocalSecondaryIndexes {
+		actualLocalIndexes[aws.StringValue(index.IndexName)] = index
+	}
+	for _, index := range expected.LocalSecondaryIndexes {
+		name := aws.StringValue(index.IndexName)
+		actual, ok := actualLocalIndexes[name]
+		delete(actualLocalIndexes, name)
+		if !ok {
+			compare(fmt.Sprintf("local index %v", name), "present", absent)
+			continue
+		}
+		compare(fmt.Sprintf("local index %v key schema", name), formatKeySchema(index.KeySchema), formatKeySchema(actual.KeySchema))
+		compare(fmt.Sprintf("local index %v projection", name), formatProjection(index.Projection), formatProjection(actual.Projection))
+	}
+	for name := range actualLocalIndexes {
+		compare(fmt.Sprintf("local index %v", name), absent, "present")
+	}
+
+	expectedTTL := dynamodb.TimeToLiveStatusDisabled
+	if r.schema.TimeToLiveAttribute != "" {
+		expectedTTL = fmt.Sprintf("%v on %v", dynamodb.TimeToLiveStatusEnabled, r.schema.TimeToLiveAttribute)
+	}
+	compare("time to live", expectedTTL, formatTTL(ttl))
+
+	compare("stream", formatStream(expected.StreamSpecification), formatStream(table.StreamSpecification))
+
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Setting < drifts[j].Setting
+	})
+	return drifts, nil
+}
+
+func formatKeySchema(elements []*dynamodb.KeySchemaElement) string {
+	var keys []string
+	for _, element := range elements {
+		keys = append(keys, fmt.Sprintf("%v %v", aws.StringValue(element.AttributeName), aws.StringValue(element.KeyType)))
+	}
+	return strings.Join(keys, ", ")
+}
+
+func formatAttributeDefinitions(definitions []*dynamodb.AttributeDefinition) string {
+	var attributes []string
+	for _, definition := range definitions {
+		attributes = append(attributes, fmt.Sprintf("%v %v", aws.StringValue(definition.AttributeName), aws.StringValue(definition.AttributeType)))
+	}
+	sort.Strings(attributes)
+	return strings.Join(attributes, ", ")
+}
+
+func formatProjection(projection *dynamodb.Projection) string {
+	if projection == nil {
+		return absent
+	}
+	if len(projection.NonKeyAttributes) == 0 {
+		return aws.StringValue(projection.ProjectionType)
+	}
+	attributes := aws.StringValueSlice(projection.NonKeyAttributes)
+	sort.Strings(attributes)
+	return fmt.Sprintf("%v (%v)", aws.StringValue(projection.ProjectionType), strings.Join(attributes, ", "))
+}
+
+func formatThroughput(throughput *dynamodb.ProvisionedThroughput) string {
+	return fmt.Sprintf("%v read, %v write", aws.Int64Value(throughput.ReadCapacityUnits), aws.Int64Value(throughput.WriteCapacityUnits))
+}
+
+func formatThroughputDescription(throughput *dynamodb.ProvisionedThroughputDescription) string {
+	if throughput == nil {
+		return absent
+	}
+	return fmt.Sprintf("%v read, %v write", aws.Int64Value(throughput.ReadCapacityUnits), aws.Int64Value(throughput.WriteCapacityUnits))
+}
+
+// formatTTL treats a time to live which is being enabled or disabled like one that already is
+func formatTTL(ttl *dynamodb.TimeToLiveDescription) string {
+	if ttl == nil {
+		return dynamodb.TimeToLiveStatusDisabled
+	}
+	switch aws.StringValue(ttl.TimeToLiveStatus) {
+	case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+		return fmt.Sprintf("%v on %v", dynamodb.TimeToLiveStatusEnabled, aws.StringValue(ttl.AttributeName))
+	default:
+		return dynamodb.TimeToLiveStatusDisabled
+	}
+}
+
+func formatStream(stream *dynamodb.StreamSpecification) string {
+	if stream == nil || !aws.BoolValue(stream.StreamEnabled) {
+		return "disabled"
+	}
+	return aws.StringValue(stream.StreamViewType)
+}
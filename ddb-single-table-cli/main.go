@@ -6,10 +6,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/fstehle/dynamodb-single-table-example/common"
+	"github.com/fstehle/dynamodb-single-table-example/generator"
 	"github.com/fstehle/dynamodb-single-table-example/loader"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"io"
 	"os"
+	"time"
 )
 
 var (
@@ -17,13 +20,126 @@ var (
 
 	awsRegion         = app.Flag("aws-region", "aws-region").Default("eu-central-1").String()
 	dynamoDBTableName = app.Flag("dynamodb-table-name", "dynamodb-table-name").Default("dynamodb-single-table-example").String()
+	billingMode       = app.Flag("billing-mode", "Billing mode of the table, new tables are created on-demand and existing tables keep their billing mode and throughput if not set.").Enum(dynamodb.BillingModePayPerRequest, dynamodb.BillingModeProvisioned)
+	readCapacity      = app.Flag("read-capacity", "Provisioned read capacity units of the table.").Default("5").Int64()
+	writeCapacity     = app.Flag("write-capacity", "Provisioned write capacity units of the table.").Default("5").Int64()
+	indexCapacity     = app.Flag("index-capacity", "Provisioned read:write capacity units of a global secondary index, e.g. gsi_1=10:5.").StringMap()
+	blobDirectory     = app.Flag("blob-directory", "Directory of a local blob store for large binary attributes, empty to keep them in the table.").String()
+	blobThreshold     = app.Flag("blob-threshold", "Size in bytes above which binary attributes are moved into the blob store.").Default("16384").Int()
+	streamViewType    = app.Flag("stream-view-type", "Enable DynamoDB Streams with this view type.").Enum(dynamodb.StreamViewTypeKeysOnly, dynamodb.StreamViewTypeNewImage, dynamodb.StreamViewTypeOldImage, dynamodb.StreamViewTypeNewAndOldImages)
 
 	createTable               = app.Command("create-table", "Create the dynamoDB table.")
+	ensureTable               = app.Command("ensure-table", "Create the dynamoDB table or update it to the table schema.")
+	ensureTableRecreateIndex  = ensureTable.Flag("recreate-index", "Recreate this global secondary index if its keys or projection differ, it cannot be queried until it is backfilled, repeatable.").Strings()
 	deleteTable               = app.Command("delete-table", "Delete the dynamoDB table.")
+	verifyTable               = app.Command("verify-table", "Compare the dynamoDB table with the table schema.")
 	purgeTable                = app.Command("purge-table", "Remove all the dynamoDB table data.")
+	purgeTableWorkers         = purgeTable.Flag("workers", "Number of parallel scan segments.").Default("4").Int()
+	purgeTableEntity          = purgeTable.Flag("entity", "Remove only items of this entity type, e.g. orders.").String()
+	purgeTableFilter          = purgeTable.Flag("filter", "Remove only items matching this filter expression.").String()
+	purgeTableFilterValues    = purgeTable.Flag("filter-value", "String value of a filter expression placeholder, e.g. :country=Germany.").StringMap()
+	purgeTableDryRun          = purgeTable.Flag("dry-run", "Only count the items that would be removed.").Bool()
+	purgeTableIncludeMeta     = purgeTable.Flag("include-meta", "Also remove the migration history and lock.").Bool()
+	addIndex                  = app.Command("add-index", "Add a global secondary index defined in the table schema.")
+	addIndexName              = addIndex.Arg("index-name", "index-name").Required().String()
+	addIndexTimeout           = addIndex.Flag("timeout", "Maximum time to wait until the index is backfilled.").Default("24h").Duration()
+	removeIndex               = app.Command("remove-index", "Remove a global secondary index.")
+	removeIndexName           = removeIndex.Arg("index-name", "index-name").Required().String()
+	removeIndexTimeout        = removeIndex.Flag("timeout", "Maximum time to wait until the index is removed.").Default("1h").Duration()
 	loadTableData             = app.Command("load-table-data", "Load data into the dynamoDB table.")
 	loadTableDataCsvDirectory = loadTableData.Flag("csv-directory", "csv-directory").Default("csv").String()
+	loadTableDataArchive      = loadTableData.Flag("archive", "Tar or zip archive holding the data files, --archive=- reads it from stdin.").String()
+	loadTableDataFormat       = loadTableData.Flag("format", "Format of the data files, by default chosen by their extension.").Enum(loader.Formats...)
+	loadTableDataWorkers      = loadTableData.Flag("workers", "Number of workers writing in parallel.").Default("4").Int()
+	loadTableDataWorkerRate   = loadTableData.Flag("worker-write-rate", "Maximum items written per second by each worker, 0 for no limit.").Default("0").Float64()
+	loadTableDataQueueSize    = loadTableData.Flag("queue-size", "Number of rows read ahead of the workers.").Default("1000").Int()
+	loadTableDataReport       = loadTableData.Flag("report-interval", "Interval between throughput reports.").Default("10s").Duration()
+	loadTableDataCheckpoint   = loadTableData.Flag("checkpoint-file", "File recording the rows written so far, empty to disable checkpoints.").Default(".load-checkpoint.json").String()
+	loadTableDataCheckpointN  = loadTableData.Flag("checkpoint-rows", "Number of rows written between two checkpoints.").Default("1000").Int()
+	loadTableDataResume       = loadTableData.Flag("resume", "Continue behind the last checkpoint.").Bool()
+	loadTableDataStrict       = loadTableData.Flag("strict", "Refuse to load data which fails validation.").Bool()
+	loadTableDataSkipChecks   = loadTableData.Flag("skip-integrity-checks", "Load without validating the rows and without waiting for each phase of entities.").Bool()
+	loadTableDataNullTokens   = loadTableData.Flag("null-token", "CSV value marking a missing value, repeatable.").Default("NULL").Strings()
+	loadTableDataMissing      = loadTableData.Flag("missing-values", "Leave missing values out of the items or store them as DynamoDB NULL.").Default(common.MissingValuesAbsent).Enum(common.MissingValuesAbsent, common.MissingValuesNull)
+	throughputController      = loadTableData.Flag("throughput-controller", "Adjust the provisioned throughput to the consumed capacity while loading.").Bool()
+	controllerMinRead         = loadTableData.Flag("controller-min-read", "Minimum read capacity units set by the throughput controller.").Default("5").Int64()
+	controllerMaxRead         = loadTableData.Flag("controller-max-read", "Maximum read capacity units set by the throughput controller.").Default("100").Int64()
+	controllerMinWrite        = loadTableData.Flag("controller-min-write", "Minimum write capacity units set by the throughput controller.").Default("5").Int64()
+	controllerMaxWrite        = loadTableData.Flag("controller-max-write", "Maximum write capacity units set by the throughput controller.").Default("100").Int64()
+	controllerIndexLimits     = loadTableData.Flag("controller-index-limits", "Capacity limits minRead:maxRead:minWrite:maxWrite of a global secondary index, e.g. gsi_1=5:50:5:200.").StringMap()
+	controllerTarget          = loadTableData.Flag("controller-target-utilization", "Target utilization of the provisioned throughput.").Default("0.7").Float64()
+	controllerInterval        = loadTableData.Flag("controller-interval", "Interval between throughput adjustments.").Default("1m").Duration()
+	controllerUpCooldown      = loadTableData.Flag("controller-scale-up-cooldown", "Minimum time between two scale ups.").Default("1m").Duration()
+	controllerDownCooldown    = loadTableData.Flag("controller-scale-down-cooldown", "Minimum time between two scale downs.").Default("15m").Duration()
+	enableTTL                 = app.Command("enable-ttl", "Enable time to live on the dynamoDB table.")
+	describeTTL               = app.Command("describe-ttl", "Show the time to live settings of the dynamoDB table.")
+	setCapacity               = app.Command("set-capacity", "Switch the dynamoDB table to --billing-mode and apply the provisioned throughput.")
 	runQueries                = app.Command("run-queries", "Run some queries within the dynamoDB table.")
+	backup                    = app.Command("backup", "Manage on-demand backups of the dynamoDB table.")
+	backupCreate              = backup.Command("create", "Create a backup and prune backups outside of the retention.")
+	backupCreateName          = backupCreate.Flag("name", "Name of the backup, defaults to the table name and the current time.").String()
+	backupRetainMaxAge        = backupCreate.Flag("retain-max-age", "Delete backups older than this, e.g. 720h.").Duration()
+	backupRetainMaxCount      = backupCreate.Flag("retain-max-count", "Keep at most this number of backups.").Int()
+	backupList                = backup.Command("list", "List the backups.")
+	backupDelete              = backup.Command("delete", "Delete a backup.")
+	backupDeleteArn           = backupDelete.Arg("backup-arn", "backup-arn").Required().String()
+	backupRestore             = backup.Command("restore", "Restore a backup into a new table.")
+	backupRestoreArn          = backupRestore.Arg("backup-arn", "backup-arn").Required().String()
+	backupRestoreTarget       = backupRestore.Flag("target", "Name of the restored table.").Required().String()
+	backupRestoreTimeout      = backupRestore.Flag("timeout", "Maximum time to wait until the restored table is active.").Default("24h").Duration()
+	pitr                      = app.Command("pitr", "Manage point in time recovery of the dynamoDB table.")
+	pitrEnable                = pitr.Command("enable", "Enable point in time recovery.")
+	pitrStatus                = pitr.Command("status", "Show the point in time recovery status and restorable times.")
+	pitrRestore               = pitr.Command("restore", "Restore the table to a point in time into a new table.")
+	pitrRestoreTarget         = pitrRestore.Flag("target", "Name of the restored table.").Required().String()
+	pitrRestoreTime           = pitrRestore.Flag("time", "Point in time in RFC 3339 format, defaults to the latest restorable time.").String()
+	pitrRestoreTimeout        = pitrRestore.Flag("timeout", "Maximum time to wait until the restored table is active.").Default("24h").Duration()
+	copyTable                 = app.Command("copy-table", "Copy all items of a dynamoDB table into another table.")
+	copyTableSource           = copyTable.Flag("source", "Name of the table to copy from.").Required().String()
+	copyTableTarget           = copyTable.Flag("target", "Name of the table to copy to.").Required().String()
+	copyTableTransform        = copyTable.Flag("transform", "Key transformation applied to the items, e.g. v1-to-v2.").String()
+	copyTableSegments         = copyTable.Flag("segments", "Number of parallel scan segments.").Default("4").Int()
+	copyTableWriteRate        = copyTable.Flag("write-rate", "Maximum items written per second, 0 for no limit.").Default("0").Float64()
+	copyTableSampleSize       = copyTable.Flag("sample-size", "Number of items whose checksum is compared after copying.").Default("100").Int()
+	validateData              = app.Command("validate-data", "Validate the references, keys and values of the source data.")
+	validateDataCsvDirectory  = validateData.Flag("csv-directory", "csv-directory").Default("csv").String()
+	validateDataArchive       = validateData.Flag("archive", "Tar or zip archive holding the data files, --archive=- reads it from stdin.").String()
+	validateDataFormat        = validateData.Flag("format", "Format of the data files, by default chosen by their extension.").Enum(loader.Formats...)
+	validateDataNullTokens    = validateData.Flag("null-token", "CSV value marking a missing value, repeatable.").Default("NULL").Strings()
+	generateData              = app.Command("generate-data", "Generate a synthetic data set in the shape of the Northwind data.")
+	generateDataSeed          = generateData.Flag("seed", "Seed of the generator, the same seed generates the same data.").Default("1").Int64()
+	generateDataCategories    = generateData.Flag("categories", "Number of categories.").Default("8").Int()
+	generateDataSuppliers     = generateData.Flag("suppliers", "Number of suppliers.").Default("50").Int()
+	generateDataShippers      = generateData.Flag("shippers", "Number of shippers.").Default("3").Int()
+	generateDataEmployees     = generateData.Flag("employees", "Number of employees.").Default("20").Int()
+	generateDataCustomers     = generateData.Flag("customers", "Number of customers.").Default("1000").Int()
+	generateDataProducts      = generateData.Flag("products", "Number of products.").Default("500").Int()
+	generateDataOrders        = generateData.Flag("orders", "Number of orders.").Default("100000").Int()
+	generateDataMaxLines      = generateData.Flag("max-lines-per-order", "Maximum number of order details of an order.").Default("5").Int()
+	generateDataProductSkew   = generateData.Flag("product-skew", "Zipf exponent of the product popularity, 0 for equally popular products.").Default("1").Float64()
+	generateDataCustomerSkew  = generateData.Flag("customer-skew", "Zipf exponent of the number of orders per customer, 0 for equal numbers.").Default("0.8").Float64()
+	generateDataStartDate     = generateData.Flag("start-date", "First order date, e.g. 2020-01-01.").Default("2020-01-01").String()
+	generateDataEndDate       = generateData.Flag("end-date", "Last order date.").Default("2022-12-31").String()
+	generateDataSeasonality   = generateData.Flag("seasonality", "Amplitude of the yearly cycle of orders relative to the mean, 0 to 1.").Default("0.3").Float64()
+	generateDataSeasonalPeak  = generateData.Flag("seasonal-peak", "Month with the most orders, 1 to 12.").Default("12").Int()
+	generateDataOutput        = generateData.Flag("output", "Write CSV files or load the data into the dynamoDB table.").Default("csv").Enum("csv", "table")
+	generateDataCsvDirectory  = generateData.Flag("csv-directory", "Directory of the CSV files.").Default("generated").String()
+	generateDataWorkers       = generateData.Flag("workers", "Number of workers writing in parallel into the table.").Default("4").Int()
+	validateAccessPatterns    = app.Command("validate-access-patterns", "Validate the access pattern catalog against the key design.")
+	migrate                   = app.Command("migrate", "Migrate the data layout of the dynamoDB table.")
+	migrateUp                 = migrate.Command("up", "Apply pending migrations.")
+	migrateUpTarget           = migrateUp.Flag("to", "Apply migrations up to and including this migration ID.").String()
+	migrateDown               = migrate.Command("down", "Revert applied migrations.")
+	migrateDownSteps          = migrateDown.Flag("steps", "Number of migrations to revert.").Default("1").Int()
+	migrateStatus             = migrate.Command("status", "Show applied and pending migrations.")
+	exportTable               = app.Command("export-table", "Export all items of the dynamoDB table to local files.")
+	exportTableDirectory      = exportTable.Flag("directory", "Directory to write the export to.").Default("export").String()
+	exportTableFormat         = exportTable.Flag("format", "Format of the exported items.").Default(common.ExportFormatDynamoDBJSON).Enum(common.ExportFormatDynamoDBJSON, common.ExportFormatJSONL)
+	exportTableGzip           = exportTable.Flag("gzip", "Compress the exported files with gzip.").Bool()
+	exportTableSegments       = exportTable.Flag("segments", "Number of parallel scan segments.").Default("4").Int()
+	importTable               = app.Command("import-table", "Import an export into the dynamoDB table.")
+	importTableDirectory      = importTable.Flag("directory", "Directory of the export.").Default("export").String()
+	importTableWorkers        = importTable.Flag("workers", "Number of files imported in parallel.").Default("4").Int()
 )
 
 // Injected with -ldflags
@@ -40,40 +156,382 @@ func main() {
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region: aws.String(*awsRegion),
 	}))
+	schema, err := tableSchema()
+	if err != nil {
+		log.WithError(err).Fatal("Invalid table schema")
+	}
+	// Every command checks that the table schema serves the access patterns the repository queries
+	err = common.ValidateAccessPatterns(schema, common.AccessPatterns(), common.EntityKeySchemas())
+	if err != nil {
+		log.WithError(err).Fatal("Access patterns are not valid")
+	}
 
 	switch command {
 
 	case createTable.FullCommand():
-		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName)
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
 		err := tableManager.CreateTable()
 		if err != nil {
 			log.WithError(err).Fatal("Could not create table")
 		}
 
+	case ensureTable.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		changes, err := tableManager.EnsureTable(common.EnsureOptions{RecreateIndexes: *ensureTableRecreateIndex})
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if err != nil {
+			log.WithError(err).Fatal("Could not ensure table")
+		}
+
 	case deleteTable.FullCommand():
-		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName)
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
 		err := tableManager.DeleteTable()
 		if err != nil {
 			log.WithError(err).Fatal("Could not delete table")
 		}
 
+	case verifyTable.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		drifts, err := tableManager.Verify()
+		if err != nil {
+			log.WithError(err).Fatal("Could not verify table")
+		}
+		for _, drift := range drifts {
+			fmt.Printf("%v\n  - %v\n  + %v\n", drift.Setting, drift.Expected, drift.Actual)
+		}
+		if len(drifts) > 0 {
+			log.WithField("drifts", len(drifts)).Fatal("Table differs from the table schema")
+		}
+		log.WithField("table", *dynamoDBTableName).Info("Table matches the table schema")
+
 	case purgeTable.FullCommand():
-		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName)
-		err := tableManager.PurgeTable()
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		filterValues := map[string]*dynamodb.AttributeValue{}
+		for name, value := range *purgeTableFilterValues {
+			filterValues[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+		_, err := tableManager.PurgeTable(common.PurgeOptions{
+			Workers:                   *purgeTableWorkers,
+			EntityType:                *purgeTableEntity,
+			FilterExpression:          *purgeTableFilter,
+			ExpressionAttributeValues: filterValues,
+			DryRun:                    *purgeTableDryRun,
+			IncludeMeta:               *purgeTableIncludeMeta,
+		})
 		if err != nil {
 			log.WithError(err).Fatal("Could not purge table")
 		}
 
+	case addIndex.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		tableManager.SetIndexTimeout(*addIndexTimeout)
+		err := tableManager.AddIndex(*addIndexName)
+		if err != nil {
+			log.WithError(err).Fatal("Could not add index")
+		}
+
+	case removeIndex.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		tableManager.SetIndexTimeout(*removeIndexTimeout)
+		err := tableManager.RemoveIndex(*removeIndexName)
+		if err != nil {
+			log.WithError(err).Fatal("Could not remove index")
+		}
+
+	case enableTTL.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		err := tableManager.EnableTTL()
+		if err != nil {
+			log.WithError(err).Fatal("Could not enable time to live")
+		}
+
+	case describeTTL.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		description, err := tableManager.DescribeTTL()
+		if err != nil {
+			log.WithError(err).Fatal("Could not describe time to live")
+		}
+		log.WithFields(log.Fields{
+			"status":    aws.StringValue(description.TimeToLiveStatus),
+			"attribute": aws.StringValue(description.AttributeName),
+		}).Info("Time to live")
+
+	case setCapacity.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		err := tableManager.SetCapacity()
+		if err != nil {
+			log.WithError(err).Fatal("Could not set capacity")
+		}
+
 	case loadTableData.FullCommand():
-		repository := common.NewRepository(dynamodb.New(sess), *dynamoDBTableName)
-		myLoader := loader.NewLoader(*loadTableDataCsvDirectory, repository)
-		err := myLoader.Load()
+		repository := newRepository(sess, schema)
+		err := repository.SetMissingValues(*loadTableDataMissing)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid missing values mode")
+		}
+		if *throughputController {
+			tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+			indexLimits, err := throughputIndexLimits(schema)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid throughput controller limits")
+			}
+			controller := common.NewThroughputController(tableManager, repository.ConsumedCapacity(), common.ThroughputControllerConfig{
+				TargetUtilization: *controllerTarget,
+				Interval:          *controllerInterval,
+				ScaleUpCooldown:   *controllerUpCooldown,
+				ScaleDownCooldown: *controllerDownCooldown,
+				Table: common.ThroughputLimits{
+					MinRead:  *controllerMinRead,
+					MaxRead:  *controllerMaxRead,
+					MinWrite: *controllerMinWrite,
+					MaxWrite: *controllerMaxWrite,
+				},
+				Indexes: indexLimits,
+			})
+			stop := make(chan struct{})
+			defer close(stop)
+			go controller.Run(stop)
+		}
+
+		source := newSource(*loadTableDataCsvDirectory, *loadTableDataArchive, *loadTableDataFormat)
+		if closer, ok := source.(io.Closer); ok {
+			defer loader.Close(closer)
+		}
+		myLoader := loader.NewLoader(source, repository, loader.LoaderConfig{
+			Workers:             *loadTableDataWorkers,
+			WorkerWriteRate:     *loadTableDataWorkerRate,
+			QueueSize:           *loadTableDataQueueSize,
+			ReportInterval:      *loadTableDataReport,
+			CheckpointFile:      *loadTableDataCheckpoint,
+			CheckpointRows:      *loadTableDataCheckpointN,
+			Resume:              *loadTableDataResume,
+			Strict:              *loadTableDataStrict,
+			SkipIntegrityChecks: *loadTableDataSkipChecks,
+			NullTokens:          *loadTableDataNullTokens,
+		})
+		err = myLoader.Load()
+		if err != nil {
+			log.WithError(err).Fatal("error loading data")
+		}
+
+	case migrateUp.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		migrator := common.NewMigrator(dynamodb.New(sess), *dynamoDBTableName, tableManager, common.Migrations())
+		err := migrator.Up(*migrateUpTarget)
+		if err != nil {
+			log.WithError(err).Fatal("Could not apply migrations")
+		}
+
+	case migrateDown.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		migrator := common.NewMigrator(dynamodb.New(sess), *dynamoDBTableName, tableManager, common.Migrations())
+		err := migrator.Down(*migrateDownSteps)
+		if err != nil {
+			log.WithError(err).Fatal("Could not revert migrations")
+		}
+
+	case migrateStatus.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		migrator := common.NewMigrator(dynamodb.New(sess), *dynamoDBTableName, tableManager, common.Migrations())
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.WithError(err).Fatal("Could not get migration status")
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-45s %-25s %s\n", status.ID, appliedAt, status.Description)
+		}
+
+	case exportTable.FullCommand():
+		exporter := common.NewExporter(dynamodb.New(sess), *dynamoDBTableName)
+		_, err := exporter.Export(*exportTableDirectory, *exportTableFormat, *exportTableGzip, *exportTableSegments)
+		if err != nil {
+			log.WithError(err).Fatal("Could not export table")
+		}
+
+	case importTable.FullCommand():
+		exporter := common.NewExporter(dynamodb.New(sess), *dynamoDBTableName)
+		_, err := exporter.Import(*importTableDirectory, *importTableWorkers)
+		if err != nil {
+			log.WithError(err).Fatal("Could not import table")
+		}
+
+	case backupCreate.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		name := *backupCreateName
+		if name == "" {
+			name = fmt.Sprintf("%s-%s", *dynamoDBTableName, time.Now().UTC().Format("20060102-150405"))
+		}
+		_, err := tableManager.CreateBackup(name)
+		if err != nil {
+			log.WithError(err).Fatal("Could not create backup")
+		}
+		_, err = tableManager.PruneBackups(common.BackupRetention{
+			MaxAge:   *backupRetainMaxAge,
+			MaxCount: *backupRetainMaxCount,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("Could not prune backups")
+		}
+
+	case backupList.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		backups, err := tableManager.ListBackups()
+		if err != nil {
+			log.WithError(err).Fatal("Could not list backups")
+		}
+		for _, backup := range backups {
+			fmt.Printf("%-25s %-10s %12d %-40s %s\n", aws.TimeValue(backup.BackupCreationDateTime).Format(time.RFC3339), aws.StringValue(backup.BackupStatus),
+				aws.Int64Value(backup.BackupSizeBytes), aws.StringValue(backup.BackupName), aws.StringValue(backup.BackupArn))
+		}
+
+	case backupDelete.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		err := tableManager.DeleteBackup(*backupDeleteArn)
+		if err != nil {
+			log.WithError(err).Fatal("Could not delete backup")
+		}
+
+	case backupRestore.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		tableManager.SetRestoreTimeout(*backupRestoreTimeout)
+		err := tableManager.RestoreBackup(*backupRestoreArn, *backupRestoreTarget)
+		if err != nil {
+			log.WithError(err).Fatal("Could not restore backup")
+		}
+
+	case pitrEnable.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		err := tableManager.EnablePITR()
+		if err != nil {
+			log.WithError(err).Fatal("Could not enable point in time recovery")
+		}
+
+	case pitrStatus.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		description, err := tableManager.DescribePITR()
+		if err != nil {
+			log.WithError(err).Fatal("Could not describe point in time recovery")
+		}
+		log.WithFields(log.Fields{
+			"status":   aws.StringValue(description.PointInTimeRecoveryStatus),
+			"earliest": aws.TimeValue(description.EarliestRestorableDateTime),
+			"latest":   aws.TimeValue(description.LatestRestorableDateTime),
+		}).Info("Point in time recovery")
+
+	case pitrRestore.FullCommand():
+		tableManager := common.NewTableManager(dynamodb.New(sess), *dynamoDBTableName, schema)
+		tableManager.SetRestoreTimeout(*pitrRestoreTimeout)
+		var restoreTime time.Time
+		if *pitrRestoreTime != "" {
+			restoreTime, err = time.Parse(time.RFC3339, *pitrRestoreTime)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid point in time")
+			}
+		}
+		err = tableManager.RestorePointInTime(*pitrRestoreTarget, restoreTime)
+		if err != nil {
+			log.WithError(err).Fatal("Could not restore table")
+		}
+
+	case copyTable.FullCommand():
+		var transformation *common.KeyTransformation
+		if *copyTableTransform != "" {
+			transformation = common.KeyTransformationByName(*copyTableTransform)
+			if transformation == nil {
+				log.WithField("transformation", *copyTableTransform).Fatal("Unknown key transformation")
+			}
+		}
+		copier := common.NewTableCopier(dynamodb.New(sess), *copyTableSource, *copyTableTarget)
+		_, err := copier.Copy(common.CopyOptions{
+			Segments:       *copyTableSegments,
+			Transformation: transformation,
+			WriteRate:      *copyTableWriteRate,
+			SampleSize:     *copyTableSampleSize,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("Could not copy table")
+		}
+
+	case validateData.FullCommand():
+		source := newSource(*validateDataCsvDirectory, *validateDataArchive, *validateDataFormat)
+		if closer, ok := source.(io.Closer); ok {
+			defer loader.Close(closer)
+		}
+		myLoader := loader.NewLoader(source, nil, loader.LoaderConfig{
+			NullTokens: *validateDataNullTokens,
+		})
+		violations, err := myLoader.Validate()
+		if err != nil {
+			log.WithError(err).Fatal("Could not validate data")
+		}
+		for _, violation := range violations {
+			fmt.Println(violation)
+		}
+		if len(violations) > 0 {
+			log.WithField("violations", len(violations)).Fatal("Data is not valid")
+		}
+		log.Info("Data is valid")
+
+	case generateData.FullCommand():
+		startDate, err := time.Parse("2006-01-02", *generateDataStartDate)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid start date")
+		}
+		endDate, err := time.Parse("2006-01-02", *generateDataEndDate)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid end date")
+		}
+		dataGenerator, err := generator.NewGenerator(generator.GeneratorConfig{
+			Seed:             *generateDataSeed,
+			Categories:       *generateDataCategories,
+			Suppliers:        *generateDataSuppliers,
+			Shippers:         *generateDataShippers,
+			Employees:        *generateDataEmployees,
+			Customers:        *generateDataCustomers,
+			Products:         *generateDataProducts,
+			Orders:           *generateDataOrders,
+			MaxLinesPerOrder: *generateDataMaxLines,
+			ProductSkew:      *generateDataProductSkew,
+			CustomerSkew:     *generateDataCustomerSkew,
+			StartDate:        startDate,
+			EndDate:          endDate,
+			Seasonality:      *generateDataSeasonality,
+			SeasonalPeak:     time.Month(*generateDataSeasonalPeak),
+		})
+		if err != nil {
+			log.WithError(err).Fatal("Invalid generator configuration")
+		}
+
+		if *generateDataOutput == "csv" {
+			err = loader.WriteCSV(dataGenerator, *generateDataCsvDirectory)
+			if err != nil {
+				log.WithError(err).Fatal("Could not write generated data")
+			}
+			log.WithField("csv_directory", *generateDataCsvDirectory).Info("Generated data")
+			break
+		}
+
+		// The generated data is consistent, loading it without integrity checks keeps the memory use constant
+		myLoader := loader.NewLoader(dataGenerator, newRepository(sess, schema), loader.LoaderConfig{
+			Workers:             *generateDataWorkers,
+			SkipIntegrityChecks: true,
+		})
+		err = myLoader.Load()
 		if err != nil {
 			log.WithError(err).Fatal("error loading data")
 		}
 
+	case validateAccessPatterns.FullCommand():
+		log.WithField("access_patterns", len(common.AccessPatterns())).Info("Access patterns are valid")
+
 	case runQueries.FullCommand():
-		repository := common.NewRepository(dynamodb.New(sess), *dynamoDBTableName)
+		repository := newRepository(sess, schema)
 		// a. Get employee by employee ID
 		// table.query(KeyConditionExpression=Key('pk').eq('employees#2'))
 		employee, err := repository.GetEmployee(2)
@@ -99,7 +557,7 @@ func main() {
 		log.WithField("direct_report_names", directReportNames).Info("Sucessfully retrieved direct reports for an employee")
 
 		// c. Get discontinued products
-		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('PRODUCT') & Key('data').eq('1'))
+		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#discontinued-products'))
 		discontinuedProducts, err := repository.GetProductsDiscontinued()
 		if err != nil {
 			log.WithError(err).Fatal("error getting discontinued products")
@@ -139,8 +597,23 @@ func main() {
 			"order_ids": recentOrderIds,
 		}).Info("Sucessfully retrieved the most recent 25 orders")
 
+		// # e2. List all orders of a customer
+		// table.query(IndexName='gsi_2',KeyConditionExpression=Key('data').eq('VINET') & Key('sk').eq('ORDER'))
+		customerOrders, err := repository.GetOrdersOfCustomer("VINET")
+		if err != nil {
+			log.WithField("customer_id", "VINET").WithError(err).Fatal("error getting all orders of a customer")
+		}
+		var customerOrderIds []int
+		for _, o := range customerOrders {
+			customerOrderIds = append(customerOrderIds, o.OrderID)
+		}
+		log.WithFields(log.Fields{
+			"customer_id": "VINET",
+			"order_ids":   customerOrderIds,
+		}).Info("Sucessfully retrieved all orders of a customer")
+
 		// # f. Get shippers by name
-		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('United Package'))
+		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('shippers#United Package'))
 		shippers, err := repository.GetShippersByName("United Package")
 		if err != nil {
 			log.WithField("company_name", "United Package").WithError(err).Fatal("error getting shippers by name")
@@ -155,7 +628,7 @@ func main() {
 		}).Info("Sucessfully retrieved shippers by name")
 
 		// # g. Get customers by contact name
-		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('Maria Anders'))
+		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('customers#Maria Anders'))
 		customers, err := repository.GetCustomersByContactName("Maria Anders")
 		if err != nil {
 			log.WithField("contact_name", "Maria Anders").WithError(err).Fatal("error getting customers by contact name")
@@ -184,8 +657,23 @@ func main() {
 			"product_ids": productIds,
 		}).Info("Sucessfully retrieved all products included in an order")
 
+		// # h2. List all lines of an order, the most valuable line first
+		// table.query(IndexName='lsi_1',KeyConditionExpression=Key('pk').eq('10260'),ScanIndexForward=False)
+		orderLines, err := repository.GetOrderLinesByValue(10260)
+		if err != nil {
+			log.WithField("order_id", 10260).WithError(err).Fatal("error getting the lines of an order by value")
+		}
+		var orderLineProductIds []int
+		for _, o := range orderLines {
+			orderLineProductIds = append(orderLineProductIds, o.ProductID)
+		}
+		log.WithFields(log.Fields{
+			"order_id":    10260,
+			"product_ids": orderLineProductIds,
+		}).Info("Sucessfully retrieved the lines of an order by value")
+
 		// # i. Get suppliers by country and region
-		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('SUPPLIER') & Key('data').begins_with('Germany#NULL'))
+		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('SUPPLIER') & Key('data').begins_with('Germany#-'))
 		suppliers, err := repository.GetSuppliersByCountry("Germany")
 		if err != nil {
 			log.WithField("country", "Germany").WithError(err).Fatal("error getting suppliers by country and region")
@@ -198,6 +686,108 @@ func main() {
 			"country":                "Germany",
 			"supplier_company_names": supplierCompanyNames,
 		}).Info("Sucessfully retrieved suppliers by country and region")
+
+		// # j. Get customers without a fax number
+		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#customers-without-fax'))
+		customersWithoutFax, err := repository.GetCustomersWithoutFax()
+		if err != nil {
+			log.WithError(err).Fatal("error getting customers without a fax number")
+		}
+		var customerWithoutFaxIds []string
+		for _, c := range customersWithoutFax {
+			customerWithoutFaxIds = append(customerWithoutFaxIds, c.CustomerID)
+		}
+		log.WithField("customer_ids", customerWithoutFaxIds).Info("Sucessfully retrieved customers without a fax number")
+
+		// # k. Get orders awaiting shipment
+		// table.query(IndexName='gsi_1',KeyConditionExpression=Key('sk').eq('sparse#orders-awaiting-shipment'))
+		ordersAwaitingShipment, err := repository.GetOrdersAwaitingShipment()
+		if err != nil {
+			log.WithError(err).Fatal("error getting orders awaiting shipment")
+		}
+		var awaitingShipmentOrderIds []int
+		for _, o := range ordersAwaitingShipment {
+			awaitingShipmentOrderIds = append(awaitingShipmentOrderIds, o.OrderID)
+		}
+		log.WithField("order_ids", awaitingShipmentOrderIds).Info("Sucessfully retrieved orders awaiting shipment")
+
+		metrics := repository.Metrics()
+		log.WithFields(log.Fields{
+			"index_queries":    metrics.IndexQueries,
+			"fallback_fetches": metrics.FallbackFetches,
+			"fallback_items":   metrics.FallbackItems,
+		}).Info("Index projection fallback fetches")
+	}
+
+}
+
+// tableSchema returns the default table schema with the capacity given by the flags
+func tableSchema() (*common.TableSchema, error) {
+	schema := common.DefaultTableSchema()
+	schema.BillingMode = *billingMode
+	schema.ReadCapacity = *readCapacity
+	schema.WriteCapacity = *writeCapacity
+	schema.StreamViewType = *streamViewType
+
+	if len(*indexCapacity) > 0 && schema.BillingMode != dynamodb.BillingModeProvisioned {
+		return nil, fmt.Errorf("--index-capacity requires --billing-mode %v", dynamodb.BillingModeProvisioned)
+	}
+	for indexName, capacity := range *indexCapacity {
+		index := globalSecondaryIndex(schema, indexName)
+		if index == nil {
+			return nil, fmt.Errorf("unknown global secondary index %v", indexName)
+		}
+		_, err := fmt.Sscanf(capacity, "%d:%d", &index.ReadCapacity, &index.WriteCapacity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capacity %q of index %v, expected read:write", capacity, indexName)
+		}
+	}
+
+	return schema, nil
+}
+
+// throughputIndexLimits returns the throughput controller limits of the global secondary indexes with own limits
+func throughputIndexLimits(schema *common.TableSchema) (map[string]common.ThroughputLimits, error) {
+	indexLimits := map[string]common.ThroughputLimits{}
+	for indexName, value := range *controllerIndexLimits {
+		if globalSecondaryIndex(schema, indexName) == nil {
+			return nil, fmt.Errorf("unknown global secondary index %v", indexName)
+		}
+		var limits common.ThroughputLimits
+		_, err := fmt.Sscanf(value, "%d:%d:%d:%d", &limits.MinRead, &limits.MaxRead, &limits.MinWrite, &limits.MaxWrite)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limits %q of index %v, expected minRead:maxRead:minWrite:maxWrite", value, indexName)
+		}
+		indexLimits[indexName] = limits
 	}
+	return indexLimits, nil
+}
+
+func globalSecondaryIndex(schema *common.TableSchema, indexName string) *common.IndexSchema {
+	for _, index := range schema.GlobalSecondaryIndexes {
+		if index.Name == indexName {
+			return index
+		}
+	}
+	return nil
+}
 
+func newRepository(sess *session.Session, schema *common.TableSchema) *common.Repository {
+	repository := common.NewRepository(dynamodb.New(sess), *dynamoDBTableName, schema)
+	if *blobDirectory != "" {
+		repository.SetBlobStore(common.NewFileBlobStore(*blobDirectory), *blobThreshold)
+	}
+	return repository
+}
+
+// newSource returns the source of the data files, the archive if one is set and the directory otherwise. Sources
+// which hold resources implement io.Closer.
+func newSource(directory string, archive string, format string) loader.Source {
+	switch archive {
+	case "":
+		return loader.NewDirectorySource(directory, format)
+	case "-":
+		return loader.NewStdinSource(format)
+	}
+	return loader.NewArchiveSource(archive, format)
 }
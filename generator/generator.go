@@ -0,0 +1,359 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/fstehle/dynamodb-single-table-example/loader"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	dateLayout = "2006-01-02 15:04:05.000"
+	// firstOrderID continues the order IDs of the Northwind data set
+	firstOrderID = 10248
+	// maxCustomers is the number of distinct customer IDs of five letters
+	maxCustomers = 26 * 26 * 26 * 26 * 26
+)
+
+// GeneratorConfig sizes the generated data set and shapes its distributions. Products are ordered with a Zipf
+// distribution of ProductSkew, customers place orders with a Zipf distribution of CustomerSkew; 0 spreads the orders
+// evenly. Order dates lie between StartDate and EndDate and follow a yearly cycle peaking in SeasonalPeak, whose
+// amplitude relative to the mean is Seasonality.
+type GeneratorConfig struct {
+	Seed             int64
+	Categories       int
+	Suppliers        int
+	Shippers         int
+	Employees        int
+	Customers        int
+	Products         int
+	Orders           int
+	MaxLinesPerOrder int
+	ProductSkew      float64
+	CustomerSkew     float64
+	StartDate        time.Time
+	EndDate          time.Time
+	Seasonality      float64
+	SeasonalPeak     time.Month
+}
+
+// Generator generates a referentially consistent data set in the shape of the Northwind data. It is a loader.Source,
+// every row is derived from the seed alone, so the same configuration always yields the same data.
+type Generator struct {
+	config    GeneratorConfig
+	products  *distribution
+	customers *distribution
+	days      *distribution
+}
+
+func NewGenerator(config GeneratorConfig) (*Generator, error) {
+	counts := map[string]int{
+		"categories":          config.Categories,
+		"suppliers":           config.Suppliers,
+		"shippers":            config.Shippers,
+		"employees":           config.Employees,
+		"customers":           config.Customers,
+		"products":            config.Products,
+		"orders":              config.Orders,
+		"max lines per order": config.MaxLinesPerOrder,
+	}
+	for name, count := range counts {
+		if count < 1 {
+			return nil, fmt.Errorf("number of %v must be positive", name)
+		}
+	}
+	if config.Customers > maxCustomers {
+		return nil, fmt.Errorf("number of customers must not exceed %v", maxCustomers)
+	}
+	if config.ProductSkew < 0 || config.CustomerSkew < 0 {
+		return nil, fmt.Errorf("skews must not be negative")
+	}
+	if config.Seasonality < 0 || config.Seasonality > 1 {
+		return nil, fmt.Errorf("seasonality must be between 0 and 1")
+	}
+	if config.SeasonalPeak < time.January || config.SeasonalPeak > time.December {
+		return nil, fmt.Errorf("invalid seasonal peak month %v", int(config.SeasonalPeak))
+	}
+	if !config.EndDate.After(config.StartDate) {
+		return nil, fmt.Errorf("end date must be after start date")
+	}
+
+	// Weight every day of the period by the yearly cycle, orders are spread over the days by this distribution
+	peak := time.Date(2001, config.SeasonalPeak, 15, 0, 0, 0, 0, time.UTC).YearDay()
+	var weights []float64
+	for day := config.StartDate; !day.After(config.EndDate); day = day.AddDate(0, 0, 1) {
+		phase := 2 * math.Pi * float64(day.YearDay()-peak) / 365.25
+		weights = append(weights, 1+config.Seasonality*math.Cos(phase))
+	}
+
+	return &Generator{
+		config:    config,
+		products:  newZipf(config.Products, config.ProductSkew),
+		customers: newZipf(config.Customers, config.CustomerSkew),
+		days:      newDistribution(weights),
+	}, nil
+}
+
+func (g *Generator) String() string {
+	return fmt.Sprintf("generator with seed %v", g.config.Seed)
+}
+
+func (g *Generator) Read(entity string, fn func(record loader.Record) error) error {
+	var count int
+	var row func(index int) interface{}
+	switch entity {
+	case loader.EntityCategories:
+		count, row = g.config.Categories, g.category
+	case loader.EntityCustomers:
+		count, row = g.config.Customers, g.customer
+	case loader.EntityEmployees:
+		count, row = g.config.Employees, g.employee
+	case loader.EntityShippers:
+		count, row = g.config.Shippers, g.shipper
+	case loader.EntitySuppliers:
+		count, row = g.config.Suppliers, g.supplier
+	case loader.EntityProducts:
+		count, row = g.config.Products, g.product
+	case loader.EntityOrders:
+		count, row = g.config.Orders, g.order
+	case loader.EntityOrderDetails:
+		for i := 0; i < g.config.Orders; i++ {
+			for _, detail := range g.orderDetails(i) {
+				err := fn(loader.Record{Row: detail, File: entity, Line: i + 1})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	default:
+		return loader.ErrEntityNotFound
+	}
+
+	for i := 0; i < count; i++ {
+		err := fn(loader.Record{Row: row(i), File: entity, Line: i + 1})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) category(index int) interface{} {
+	name, description := fmt.Sprintf("Category %v", index+1), ""
+	if index < len(categoryNames) {
+		name, description = categoryNames[index], categoryDescriptions[index]
+	}
+	return &loader.Category{
+		CategoryID:   index + 1,
+		CategoryName: name,
+		Description:  description,
+	}
+}
+
+func (g *Generator) customer(index int) interface{} {
+	r := newRandom(g.config.Seed, loader.EntityCustomers, index)
+	location := locations[r.intn(len(locations))]
+	return &loader.Customer{
+		CustomerID:   customerID(index),
+		CompanyName:  companyName(index),
+		ContactName:  r.pick(firstNames) + " " + r.pick(lastNames),
+		ContactTitle: r.pick(contactTitles),
+		Address:      fmt.Sprintf("%v %v", r.between(1, 200), r.pick(streets)),
+		City:         location.city,
+		Region:       location.region,
+		PostalCode:   fmt.Sprintf("%05d", r.intn(100000)),
+		Country:      location.country,
+		Phone:        phone(r, location),
+		Fax:          optional(r, 0.5, phone(r, location)),
+	}
+}
+
+// employee returns an employee of a hierarchy in which the first employee manages everybody else through managers
+// with up to five direct reports
+func (g *Generator) employee(index int) interface{} {
+	r := newRandom(g.config.Seed, loader.EntityEmployees, index)
+	location := locations[r.intn(len(locations))]
+	title, reportsTo := "Sales Representative", ""
+	if index == 0 {
+		title = "Vice President, Sales"
+	} else {
+		reportsTo = strconv.Itoa((index-1)/5 + 1)
+		if index*5+1 < g.config.Employees {
+			title = "Sales Manager"
+		}
+	}
+	birthDate := time.Date(r.between(1950, 1995), time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, r.intn(365))
+	hireDate := g.config.StartDate.AddDate(0, 0, -r.intn(10*365))
+	return &loader.Employee{
+		EmployeeID:      index + 1,
+		LastName:        r.pick(lastNames),
+		FirstName:       r.pick(firstNames),
+		Title:           title,
+		TitleOfCourtesy: r.pick(courtesyTitles),
+		BirthDate:       birthDate.Format(dateLayout),
+		HireDate:        hireDate.Format(dateLayout),
+		Address:         fmt.Sprintf("%v %v", r.between(1, 200), r.pick(streets)),
+		City:            location.city,
+		Region:          location.region,
+		PostalCode:      fmt.Sprintf("%05d", r.intn(100000)),
+		Country:         location.country,
+		HomePhone:       phone(r, location),
+		Extension:       strconv.Itoa(r.between(100, 9999)),
+		ReportsTo:       reportsTo,
+	}
+}
+
+func (g *Generator) shipper(index int) interface{} {
+	r := newRandom(g.config.Seed, loader.EntityShippers, index)
+	name := fmt.Sprintf("Shipper %v", index+1)
+	if index < len(shipperNames) {
+		name = shipperNames[index]
+	}
+	return &loader.Shipper{
+		ShipperID:   index + 1,
+		CompanyName: name,
+		Phone:       fmt.Sprintf("(503) 555-%04d", r.intn(10000)),
+	}
+}
+
+func (g *Generator) supplier(index int) interface{} {
+	r := newRandom(g.config.Seed, loader.EntitySuppliers, index)
+	location := locations[r.intn(len(locations))]
+	return &loader.Supplier{
+		SupplierID:   index + 1,
+		CompanyName:  companyName(g.config.Customers + index),
+		ContactName:  r.pick(firstNames) + " " + r.pick(lastNames),
+		ContactTitle: r.pick(contactTitles),
+		Address:      fmt.Sprintf("%v %v", r.between(1, 200), r.pick(streets)),
+		City:         location.city,
+		Region:       location.region,
+		PostalCode:   fmt.Sprintf("%05d", r.intn(100000)),
+		Country:      location.country,
+		Phone:        phone(r, location),
+		Fax:          optional(r, 0.3, phone(r, location)),
+	}
+}
+
+func (g *Generator) product(index int) interface{} {
+	r := newRandom(g.config.Seed, loader.EntityProducts, index)
+	name := productWords[index%len(productWords)] + " " + productNouns[index/len(productWords)%len(productNouns)]
+	if combinations := len(productWords) * len(productNouns); index >= combinations {
+		name = fmt.Sprintf("%v %v", name, index/combinations+1)
+	}
+	discontinued := "0"
+	if r.float64() < 0.1 {
+		discontinued = "1"
+	}
+	return &loader.Product{
+		ProductID:       index + 1,
+		ProductName:     name,
+		SupplierID:      r.intn(g.config.Suppliers) + 1,
+		CategoryID:      r.intn(g.config.Categories) + 1,
+		QuantityPerUnit: r.pick(packagings),
+		UnitPrice:       money(r.logUniform(2.5, 263.5)),
+		UnitsInStock:    strconv.Itoa(r.intn(125)),
+		UnitsOnOrder:    strconv.Itoa(r.intn(10) * 10),
+		ReorderLevel:    strconv.Itoa(r.intn(7) * 5),
+		Discontinued:    discontinued,
+	}
+}
+
+// order returns an order of a customer drawn from the customer distribution. The order dates increase with the
+// order IDs, at a rate following the seasonal distribution of the days.
+func (g *Generator) order(index int) interface{} {
+	r := newRandom(g.config.Seed, loader.EntityOrders, index)
+	customer := g.customer(g.customers.sample(r.float64())).(*loader.Customer)
+	orderDate := g.config.StartDate.AddDate(0, 0, g.days.sample((float64(index)+r.float64())/float64(g.config.Orders)))
+	requiredDate := orderDate.AddDate(0, 0, []int{14, 28, 28, 28, 42}[r.intn(5)])
+	shippedDate := orderDate.AddDate(0, 0, r.between(1, 35))
+	shipped := ""
+	if !shippedDate.After(g.config.EndDate) {
+		shipped = shippedDate.Format(dateLayout)
+	}
+	return &loader.Order{
+		OrderID:        firstOrderID + index,
+		CustomerID:     customer.CustomerID,
+		EmployeeID:     r.intn(g.config.Employees) + 1,
+		OrderDate:      orderDate.Format(dateLayout),
+		RequiredDate:   requiredDate.Format(dateLayout),
+		ShippedDate:    shipped,
+		ShipVia:        strconv.Itoa(r.intn(g.config.Shippers) + 1),
+		Freight:        money(r.logUniform(0.02, 1000)),
+		ShipName:       customer.CompanyName,
+		ShipAddress:    customer.Address,
+		ShipCity:       customer.City,
+		ShipRegion:     customer.Region,
+		ShipPostalCode: customer.PostalCode,
+		ShipCountry:    customer.Country,
+	}
+}
+
+// orderDetails returns the lines of an order, distinct products drawn from the product distribution
+func (g *Generator) orderDetails(index int) []*loader.OrderDetail {
+	r := newRandom(g.config.Seed, loader.EntityOrderDetails, index)
+	lines := r.between(1, g.config.MaxLinesPerOrder)
+	if lines > g.config.Products {
+		lines = g.config.Products
+	}
+
+	var details []*loader.OrderDetail
+	ordered := map[int]bool{}
+	for attempt := 0; len(details) < lines && attempt < lines*10; attempt++ {
+		productIndex := g.products.sample(r.float64())
+		if ordered[productIndex] {
+			continue
+		}
+		ordered[productIndex] = true
+
+		discount := "0"
+		if r.float64() < 0.4 {
+			discount = []string{"0.05", "0.1", "0.15", "0.2", "0.25"}[r.intn(5)]
+		}
+		details = append(details, &loader.OrderDetail{
+			OrderID:   firstOrderID + index,
+			ProductID: productIndex + 1,
+			UnitPrice: g.product(productIndex).(*loader.Product).UnitPrice,
+			Quantity:  strconv.Itoa(1 + int(math.Min(-math.Log(1-r.float64())*15, 119))),
+			Discount:  discount,
+		})
+	}
+	return details
+}
+
+// customerID returns an ID of five letters like the Northwind customer IDs
+func customerID(index int) string {
+	id := make([]byte, 5)
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i] = byte('A' + index%26)
+		index /= 26
+	}
+	return string(id)
+}
+
+// companyName returns a distinct company name for every index
+func companyName(index int) string {
+	name := companyWords[index%len(companyWords)] + " " + companySuffixes[index/len(companyWords)%len(companySuffixes)]
+	if combinations := len(companyWords) * len(companySuffixes); index >= combinations {
+		name = fmt.Sprintf("%v %v", name, index/combinations+1)
+	}
+	return name
+}
+
+func phone(r *random, location location) string {
+	return fmt.Sprintf("%v 555-%04d", location.phone, r.intn(10000))
+}
+
+// optional returns the value with the given probability and a missing value otherwise
+func optional(r *random, probability float64, value string) string {
+	if r.float64() < probability {
+		return value
+	}
+	return ""
+}
+
+// money formats an amount with two fractional digits
+func money(amount float64) string {
+	return strconv.FormatFloat(math.Round(amount*100)/100, 'f', 2, 64)
+}
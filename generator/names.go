@@ -0,0 +1,67 @@
+package generator
+
+type location struct {
+	city    string
+	region  string
+	country string
+	phone   string
+}
+
+var (
+	categoryNames = []string{"Beverages", "Condiments", "Confections", "Dairy Products", "Grains/Cereals", "Meat/Poultry", "Produce", "Seafood"}
+
+	categoryDescriptions = []string{
+		"Soft drinks coffees teas beers and ales",
+		"Sweet and savory sauces relishes spreads and seasonings",
+		"Desserts candies and sweet breads",
+		"Cheeses",
+		"Breads crackers pasta and cereal",
+		"Prepared meats",
+		"Dried fruit and bean curd",
+		"Seaweed and fish",
+	}
+
+	shipperNames = []string{"Speedy Express", "United Package", "Federal Shipping"}
+
+	companyWords    = []string{"Alpine", "Bright", "Central", "Delta", "Eastern", "Golden", "Harbor", "Island", "Lakeside", "Meadow", "Northern", "Ocean", "Prairie", "River", "Summit", "Valley", "Western", "Royal", "Silver", "Sunny"}
+	companySuffixes = []string{"Trading", "Foods", "Markets", "Delicatessen", "Imports", "Grocers", "Supplies", "Provisions", "Traders", "Gourmet"}
+
+	productWords = []string{"Organic", "Smoked", "Spicy", "Sweet", "Aged", "Fresh", "Classic", "Wild", "Golden", "Dark", "Mild", "Rustic"}
+	productNouns = []string{"Tea", "Coffee", "Cheese", "Sauce", "Chocolate", "Bread", "Pasta", "Salmon", "Honey", "Olive Oil", "Mustard", "Biscuits", "Sausage", "Marmalade", "Crab Meat", "Tofu"}
+	packagings   = []string{"10 boxes x 20 bags", "24 - 12 oz bottles", "12 - 550 ml bottles", "48 - 6 oz jars", "36 boxes", "12 - 200 g glasses", "20 - 1 kg tins", "24 pkgs. x 4 pieces", "10 - 500 g pkgs.", "1k pkg."}
+
+	firstNames = []string{"Anna", "Carlos", "Elena", "Felix", "Hanna", "Ivan", "Julia", "Karl", "Laura", "Marco", "Nina", "Oscar", "Paula", "Rafael", "Sofia", "Tom"}
+	lastNames  = []string{"Andersen", "Bauer", "Costa", "Dubois", "Eriksson", "Fischer", "Garcia", "Hansen", "Jensen", "Kowalski", "Larsen", "Moreau", "Novak", "Rossi", "Schmidt", "Weber"}
+
+	courtesyTitles = []string{"Mr.", "Ms.", "Mrs.", "Dr."}
+	contactTitles  = []string{"Owner", "Sales Representative", "Marketing Manager", "Accounting Manager", "Order Administrator", "Purchasing Manager", "Sales Manager"}
+	streets        = []string{"Main St.", "Harbour Rd.", "Market Pl.", "Mill Lane", "Station Rd.", "Park Ave.", "Church St.", "King St."}
+
+	locations = []location{
+		{"Berlin", "", "Germany", "030"},
+		{"München", "", "Germany", "089"},
+		{"London", "", "UK", "(171)"},
+		{"Paris", "", "France", "(1)"},
+		{"Lyon", "", "France", "78"},
+		{"Madrid", "", "Spain", "(91)"},
+		{"Torino", "", "Italy", "011"},
+		{"Bern", "", "Switzerland", "0452"},
+		{"Graz", "", "Austria", "7675"},
+		{"Bruxelles", "", "Belgium", "(02)"},
+		{"Stockholm", "", "Sweden", "08"},
+		{"Helsinki", "", "Finland", "90"},
+		{"Århus", "", "Denmark", "86"},
+		{"Warszawa", "", "Poland", "(26)"},
+		{"Lisboa", "", "Portugal", "(1)"},
+		{"Cork", "Co. Cork", "Ireland", "2967"},
+		{"México D.F.", "", "Mexico", "(5)"},
+		{"São Paulo", "SP", "Brazil", "(11)"},
+		{"Buenos Aires", "", "Argentina", "(1)"},
+		{"Caracas", "DF", "Venezuela", "(2)"},
+		{"Seattle", "WA", "USA", "(206)"},
+		{"Portland", "OR", "USA", "(503)"},
+		{"Boise", "ID", "USA", "(208)"},
+		{"Vancouver", "BC", "Canada", "(604)"},
+		{"Montréal", "Québec", "Canada", "(514)"},
+	}
+)
@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// random is a small deterministic generator (splitmix64). Every row has its own generator, seeded from the seed of
+// the data set, the entity and the row, so that any row can be generated without generating the rows before it.
+type random struct {
+	state uint64
+}
+
+func newRandom(seed int64, entity string, index int) *random {
+	hash := fnv.New64a()
+	hash.Write([]byte(entity))
+	r := &random{state: uint64(seed)}
+	r.state = r.next() ^ hash.Sum64()
+	r.state = r.next() ^ uint64(index)
+	return r
+}
+
+func (r *random) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// float64 returns a number in [0, 1)
+func (r *random) float64() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+// intn returns a number in [0, n)
+func (r *random) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+// between returns a number in [min, max]
+func (r *random) between(min int, max int) int {
+	return min + r.intn(max-min+1)
+}
+
+// logUniform returns a number in [min, max) whose logarithm is uniformly distributed, as prices are
+func (r *random) logUniform(min float64, max float64) float64 {
+	return min * math.Exp(r.float64()*math.Log(max/min))
+}
+
+func (r *random) pick(values []string) string {
+	return values[r.intn(len(values))]
+}
+
+// distribution samples indexes from a discrete distribution given by its weights
+type distribution struct {
+	cumulative []float64
+}
+
+func newDistribution(weights []float64) *distribution {
+	cumulative := make([]float64, len(weights))
+	sum := 0.0
+	for i, weight := range weights {
+		sum += weight
+		cumulative[i] = sum
+	}
+	return &distribution{cumulative: cumulative}
+}
+
+// newZipf returns the distribution of n ranks whose probability is proportional to 1/rank^exponent. Exponent 0 is
+// the uniform distribution, larger exponents concentrate the samples on the first ranks.
+func newZipf(n int, exponent float64) *distribution {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1 / math.Pow(float64(i+1), exponent)
+	}
+	return newDistribution(weights)
+}
+
+// sample returns the index at the quantile u in [0, 1)
+func (d *distribution) sample(u float64) int {
+	i := sort.SearchFloat64s(d.cumulative, u*d.cumulative[len(d.cumulative)-1])
+	if i >= len(d.cumulative) {
+		i = len(d.cumulative) - 1
+	}
+	return i
+}
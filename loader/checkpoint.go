@@ -0,0 +1,92 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Checkpoint records how many rows of each entity have been read and written completely. Rows are written with puts of
+// complete items, so replaying the rows behind a checkpoint which were already written is harmless. Fingerprint
+// identifies the data of sources implementing Fingerprinter.
+type Checkpoint struct {
+	Source      string         `json:"source"`
+	Fingerprint string         `json:"fingerprint,omitempty"`
+	Entities    map[string]int `json:"entities"`
+	Completed   bool           `json:"completed"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+}
+
+// startCheckpoint returns the checkpoint to continue from when resuming and an empty checkpoint otherwise
+func (g *Loader) startCheckpoint() (*Checkpoint, error) {
+	if g.config.CheckpointFile == "" {
+		return &Checkpoint{Entities: map[string]int{}}, nil
+	}
+
+	fingerprint := ""
+	if fingerprinter, ok := g.source.(Fingerprinter); ok {
+		var err error
+		fingerprint, err = fingerprinter.Fingerprint()
+		if err != nil {
+			return nil, fmt.Errorf("could not fingerprint source %v: %v", g.source, err)
+		}
+	}
+	checkpoint := &Checkpoint{
+		Source:      g.source.String(),
+		Fingerprint: fingerprint,
+		Entities:    map[string]int{},
+	}
+	if !g.config.Resume {
+		return checkpoint, nil
+	}
+
+	data, err := ioutil.ReadFile(g.config.CheckpointFile)
+	if os.IsNotExist(err) {
+		log.WithField("checkpoint_file", g.config.CheckpointFile).Info("No checkpoint found, starting from the beginning")
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint file %v: %v", g.config.CheckpointFile, err)
+	}
+
+	err = json.Unmarshal(data, checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %v: %v", g.config.CheckpointFile, err)
+	}
+	if checkpoint.Source != g.source.String() {
+		return nil, fmt.Errorf("checkpoint file %v belongs to the source %v", g.config.CheckpointFile, checkpoint.Source)
+	}
+	if checkpoint.Fingerprint != fingerprint {
+		return nil, fmt.Errorf("checkpoint file %v belongs to other data of the source %v", g.config.CheckpointFile, checkpoint.Source)
+	}
+	if checkpoint.Entities == nil {
+		checkpoint.Entities = map[string]int{}
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint replaces the checkpoint file atomically, so that a crash never leaves a partial checkpoint behind
+func (g *Loader) saveCheckpoint(checkpoint *Checkpoint) error {
+	if g.config.CheckpointFile == "" {
+		return nil
+	}
+
+	checkpoint.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling checkpoint: %v", err)
+	}
+
+	tmpFile := g.config.CheckpointFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, g.config.CheckpointFile)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write checkpoint file %v: %v", g.config.CheckpointFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+)
+
+// csvFile writes the rows of one entity
+type csvFile struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// WriteCSV writes the data of a source into a directory as one CSV file per entity, in the layout of the Northwind
+// CSV files. Missing values are written as DefaultNullToken.
+func WriteCSV(source Source, directory string) error {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory %v: %v", directory, err)
+	}
+
+	files := map[string]*csvFile{}
+	defer func() {
+		for _, file := range files {
+			Close(file.file)
+		}
+	}()
+
+	for _, entity := range Entities {
+		err := source.Read(entity, func(record Record) error {
+			rowEntity := recordEntity(record.Row)
+			if files[rowEntity] == nil {
+				file, err := createCSVFile(path.Join(directory, rowEntity+"."+FormatCSV), record.Row)
+				if err != nil {
+					return err
+				}
+				files[rowEntity] = file
+			}
+			return files[rowEntity].writer.Write(csvValues(record.Row))
+		})
+		if err == ErrEntityNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not write %v: %v", entity, err)
+		}
+	}
+
+	for entity, file := range files {
+		file.writer.Flush()
+		err := file.writer.Error()
+		if err != nil {
+			return fmt.Errorf("could not write %v: %v", entity, err)
+		}
+	}
+	return nil
+}
+
+// createCSVFile creates a CSV file with the header of the columns of a row
+func createCSVFile(filename string, row interface{}) (*csvFile, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not create file %v: %v", filename, err)
+	}
+
+	rowType := reflect.TypeOf(row).Elem()
+	header := make([]string, rowType.NumField())
+	for i := range header {
+		header[i] = rowType.Field(i).Tag.Get("csv")
+	}
+	writer := csv.NewWriter(file)
+	err = writer.Write(header)
+	if err != nil {
+		Close(file)
+		return nil, fmt.Errorf("could not write file %v: %v", filename, err)
+	}
+	return &csvFile{file: file, writer: writer}, nil
+}
+
+// csvValues returns the values of a row in column order
+func csvValues(row interface{}) []string {
+	value := reflect.ValueOf(row).Elem()
+	values := make([]string, value.NumField())
+	for i := range values {
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.Int:
+			values[i] = strconv.FormatInt(field.Int(), 10)
+		default:
+			values[i] = field.String()
+		}
+		if values[i] == "" {
+			values[i] = DefaultNullToken
+		}
+	}
+	return values
+}
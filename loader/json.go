@@ -0,0 +1,216 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// embeddedOrderDetailFields are the fields of an order document which may hold its line items
+var embeddedOrderDetailFields = []string{"details", "orderDetails"}
+
+// document is a JSON object read from a JSON array or a JSONL file
+type document struct {
+	values map[string]interface{}
+	line   int
+}
+
+// readDocuments calls fn with the objects of a JSON array or of a file with one object per line, reading one object
+// at a time. The line of a document is its line in a JSONL file and its position in a JSON array.
+func readDocuments(reader io.Reader, file string, format string, fn func(document document) error) error {
+	if format == FormatJSONL {
+		lines := bufio.NewReader(reader)
+		for line := 1; ; line++ {
+			text, err := lines.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("could not read file %v: %v", file, err)
+			}
+			if len(bytes.TrimSpace(text)) > 0 {
+				values, decodeErr := decodeObject(json.NewDecoder(bytes.NewReader(text)))
+				if decodeErr != nil {
+					return fmt.Errorf("could not parse %v:%v: %v", file, line, decodeErr)
+				}
+				fnErr := fn(document{values, line})
+				if fnErr != nil {
+					return fnErr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+		}
+	}
+
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("could not parse JSON file %v: %v", file, err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("JSON file %v does not contain an array", file)
+	}
+	for count := 1; decoder.More(); count++ {
+		values, err := decodeObject(decoder)
+		if err != nil {
+			return fmt.Errorf("could not parse %v:%v: %v", file, count, err)
+		}
+		err = fn(document{values, count})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return fmt.Errorf("could not parse JSON file %v: %v", file, err)
+	}
+	return nil
+}
+
+func decodeObject(decoder *json.Decoder) (map[string]interface{}, error) {
+	decoder.UseNumber()
+	var values map[string]interface{}
+	err := decoder.Decode(&values)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("document is not a JSON object")
+	}
+	return values, nil
+}
+
+// decodeRow sets the fields of a row from the values of a document, matched by their CSV column names. Values are
+// converted to the text the CSV file would hold, so that the rows parse into the model the same way. Integer fields
+// whose value is not an integer, which may be a null token, are left empty and returned by column name.
+func decodeRow(values map[string]interface{}, row reflect.Value) (map[string]string, error) {
+	var malformed map[string]string
+	for i := 0; i < row.NumField(); i++ {
+		name := row.Type().Field(i).Tag.Get("csv")
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		text, err := jsonText(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v %v", name, err)
+		}
+
+		field := row.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(text)
+		case reflect.Int:
+			if text == "" {
+				continue
+			}
+			integer, err := strconv.Atoi(text)
+			if err != nil {
+				if malformed == nil {
+					malformed = map[string]string{}
+				}
+				malformed[name] = text
+				continue
+			}
+			field.SetInt(int64(integer))
+		}
+	}
+	return malformed, nil
+}
+
+// jsonText returns the text of a scalar JSON value. MongoDB extended JSON values like {"$date": ...} or
+// {"$binary": ...} are unwrapped, binaries are hex encoded as in the CSV files.
+func jsonText(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case map[string]interface{}:
+		if binary, ok := value["$binary"]; ok {
+			return extendedBinary(binary)
+		}
+		if len(value) == 1 {
+			for key, inner := range value {
+				switch key {
+				case "$date":
+					return extendedDate(inner)
+				case "$oid", "$numberInt", "$numberLong", "$numberDouble", "$numberDecimal":
+					return jsonText(inner)
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("is not a scalar value")
+}
+
+// extendedDate unwraps a $date, either a date string or milliseconds since the epoch
+func extendedDate(value interface{}) (string, error) {
+	text, err := jsonText(value)
+	if err != nil {
+		return "", err
+	}
+	if milliseconds, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return time.Unix(0, milliseconds*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), nil
+	}
+	return text, nil
+}
+
+// extendedBinary unwraps a $binary, {"base64": ..., "subType": ...} or the base64 string of the legacy format
+func extendedBinary(value interface{}) (string, error) {
+	if object, ok := value.(map[string]interface{}); ok {
+		value = object["base64"]
+	}
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("is not a base64 encoded binary value")
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", fmt.Errorf("is not a base64 encoded binary value: %v", err)
+	}
+	return "0x" + strings.ToUpper(hex.EncodeToString(data)), nil
+}
+
+// embeddedOrderDetails returns the records of the line items embedded into an order document. Line items without
+// orderID belong to the order.
+func embeddedOrderDetails(values map[string]interface{}, file string, line int) ([]Record, error) {
+	var details []Record
+	for _, field := range embeddedOrderDetailFields {
+		items, ok := values[field]
+		if !ok || items == nil {
+			continue
+		}
+		list, ok := items.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v is not an array", field)
+		}
+		for i, item := range list {
+			itemValues, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%v %v is not an object", field, i+1)
+			}
+			if _, ok := itemValues["orderID"]; !ok {
+				itemValues["orderID"] = values["orderID"]
+			}
+			detail := &OrderDetail{}
+			malformed, err := decodeRow(itemValues, reflect.ValueOf(detail).Elem())
+			if err != nil {
+				return nil, fmt.Errorf("%v %v: %v", field, i+1, err)
+			}
+			details = append(details, Record{Row: detail, File: file, Line: line, Malformed: malformed})
+		}
+	}
+	return details, nil
+}
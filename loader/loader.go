@@ -3,11 +3,13 @@ package loader
 import (
 	"fmt"
 	"github.com/fstehle/dynamodb-single-table-example/common"
-	"github.com/gocarina/gocsv"
 	log "github.com/sirupsen/logrus"
 	"io"
-	"os"
-	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Category struct {
@@ -54,7 +56,7 @@ type Employee struct {
 
 type OrderDetail struct {
 	OrderID   int    `csv:"orderID"`
-	ProductID int `csv:"productID"`
+	ProductID int    `csv:"productID"`
 	UnitPrice string `csv:"unitPrice"`
 	Quantity  string `csv:"quantity"`
 	Discount  string `csv:"discount"`
@@ -111,189 +113,421 @@ type Supplier struct {
 	HomePage     string `csv:"homePage"`
 }
 
-type LoaderData struct {
-	Categories   []*Category
-	Customers    []*Customer
-	Employees    []*Employee
-	OrderDetails []*OrderDetail
-	Orders       []*Order
-	Products     []*Product
-	Shippers     []*Shipper
-	Suppliers    []*Supplier
+// LoaderConfig configures the worker pool of the loader. Rows are read ahead of the workers into a queue of
+// QueueSize rows, reading waits while the queue is full. Every worker writes with its own batch writer, limited to
+// WorkerWriteRate items per second if set. Every CheckpointRows rows the loader waits for all writes and records the
+// rows read of every entity in CheckpointFile, so that a load started with Resume continues behind the last
+// checkpoint. Unless SkipIntegrityChecks is set, rows are validated while loading and every phase of entities is
+// written completely before the next one starts. Strict refuses to load data which fails Validate. Values equal to
+// one of the NullTokens are missing values and read as empty strings.
+type LoaderConfig struct {
+	Workers             int
+	WorkerWriteRate     float64
+	QueueSize           int
+	ReportInterval      time.Duration
+	CheckpointFile      string
+	CheckpointRows      int
+	Resume              bool
+	Strict              bool
+	SkipIntegrityChecks bool
+	NullTokens          []string
 }
 
 type Loader struct {
-	csvDirectory string
-	repository   *common.Repository
+	source     Source
+	repository *common.Repository
+	config     LoaderConfig
+	// newItems is set when loading into an empty table, so that no stale sparse index entries have to be deleted
+	newItems bool
 }
 
-func NewLoader(csvDirectory string, repository *common.Repository) *Loader {
-	return &Loader{
-		csvDirectory: csvDirectory,
-		repository:   repository,
-	}
+// loadJob stores one row with the batch repository of a worker
+type loadJob struct {
+	description string
+	store       func(repository *common.Repository) error
 }
 
-func (g *Loader) Load() error {
-	log.Info("Loading data into the dynamoDB table")
+type loadProgress struct {
+	start   time.Time
+	rows    int64
+	failed  int64
+	written int64
+}
 
-	data, err := g.loadData()
-	if err != nil {
-		return fmt.Errorf("could not load data: %v", err)
+// writerPool writes the jobs put on its queue with the workers until the queue is closed
+type writerPool struct {
+	queue  chan loadJob
+	wg     sync.WaitGroup
+	failed int64
+}
+
+func NewLoader(source Source, repository *common.Repository, config LoaderConfig) *Loader {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.QueueSize < 1 {
+		config.QueueSize = 1000
+	}
+	if config.ReportInterval <= 0 {
+		config.ReportInterval = 10 * time.Second
 	}
+	if config.CheckpointRows <= 0 {
+		config.CheckpointRows = 1000
+	}
+	if config.NullTokens == nil {
+		config.NullTokens = []string{DefaultNullToken}
+	}
+	return &Loader{
+		source:     source,
+		repository: repository,
+		config:     config,
+	}
+}
 
-	for _, dataCategory := range data.Categories {
-		fmt.Println("Hello category", dataCategory.CategoryName)
-		category := common.Category(*dataCategory)
-		err := g.repository.StoreCategory(&category)
+func (g *Loader) Load() error {
+	log.WithFields(log.Fields{
+		"source":            g.source,
+		"workers":           g.config.Workers,
+		"worker_write_rate": g.config.WorkerWriteRate,
+		"queue_size":        g.config.QueueSize,
+		"resume":            g.config.Resume,
+	}).Info("Loading data into the dynamoDB table")
+
+	if g.config.Strict {
+		if g.config.SkipIntegrityChecks {
+			return fmt.Errorf("strict loads require integrity checks")
+		}
+		violations, err := g.Validate()
 		if err != nil {
-			log.WithError(err).WithField("category_name", category.CategoryName).Errorf("cannot store category")
+			return err
+		}
+		if len(violations) > 0 {
+			LogViolations(violations)
+			return fmt.Errorf("data in %v has %v violations", g.source, len(violations))
 		}
 	}
 
-	for _, dataCustomer := range data.Customers {
-		fmt.Println("Hello customer", dataCustomer.CompanyName)
-		customer := common.Customer(*dataCustomer)
-		err := g.repository.StoreCustomer(&customer)
+	checkpoint, err := g.startCheckpoint()
+	if err != nil {
+		return err
+	}
+	if checkpoint.Completed {
+		log.WithField("checkpoint_file", g.config.CheckpointFile).Info("Load already completed")
+		return nil
+	}
+	if len(checkpoint.Entities) == 0 {
+		g.newItems, err = g.repository.IsEmpty()
 		if err != nil {
-			log.WithError(err).WithField("company_name", customer.CompanyName).Errorf("cannot store customer")
+			return err
 		}
 	}
 
-	for _, dataEmployee := range data.Employees {
-		fmt.Println("Hello employee", dataEmployee.FirstName)
-		employee := common.Employee(*dataEmployee)
-		err := g.repository.StoreEmployee(&employee)
-		if err != nil {
-			log.WithError(err).WithField("first_name", employee.FirstName).Errorf("cannot store employee")
+	progress := &loadProgress{start: time.Now()}
+	stop := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		ticker := time.NewTicker(g.config.ReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				g.report("Loading", progress)
+			}
 		}
+	}()
+
+	unparsed, err := g.stream(checkpoint, progress)
+	close(stop)
+	<-reported
+
+	g.report("Loaded data", progress)
+	if err == nil && unparsed > 0 {
+		err = fmt.Errorf("%v rows could not be parsed and were skipped", unparsed)
 	}
+	return err
+}
 
-	for _, dataOrderDetail := range data.OrderDetails {
-		fmt.Println("Hello order details", dataOrderDetail.OrderID)
-		orderDetail := common.OrderDetail(*dataOrderDetail)
-		err := g.repository.StoreOrderDetail(&orderDetail)
-		if err != nil {
-			log.WithError(err).WithField("order_id", orderDetail.OrderID).Errorf("cannot store order detail")
-		}
+// stream reads the records of the source and writes them with the worker pool, so that only the queued rows are held
+// in memory. Before a checkpoint is saved, and with integrity checks at the end of every phase, the loader waits until
+// the queued rows are written. It returns the number of rows which could not be parsed.
+func (g *Loader) stream(checkpoint *Checkpoint, progress *loadProgress) (int, error) {
+	resume := map[string]int{}
+	for entity, offset := range checkpoint.Entities {
+		resume[entity] = offset
 	}
 
-	for _, dataOrder := range data.Orders {
-		fmt.Println("Hello order", dataOrder.OrderID)
-		order := common.Order(*dataOrder)
-		err := g.repository.StoreOrder(&order)
-		if err != nil {
-			log.WithError(err).WithField("order_id", order.OrderID).Errorf("cannot store order")
-		}
+	var checker *validator
+	kinds := map[string]int{}
+	if !g.config.SkipIntegrityChecks {
+		checker = newValidator(func(violation Violation) {
+			kinds[violation.Kind]++
+			logViolation(violation)
+		}, false)
 	}
 
-	for _, dataProduct := range data.Products {
-		fmt.Println("Hello product", dataProduct.ProductName)
-		product := common.Product(*dataProduct)
-		err := g.repository.StoreProduct(&product)
+	pool := g.startPool(progress)
+	read := map[string]int{}
+	queued, unparsed := 0, 0
+	var writeErr error
+	barrier := func() error {
+		err := pool.wait(progress)
+		pool = g.startPool(progress)
 		if err != nil {
-			log.WithError(err).WithField("product_name", product.ProductName).Errorf("cannot store product")
+			return err
+		}
+		for entity, rows := range read {
+			checkpoint.Entities[entity] = rows
 		}
+		queued = 0
+		return g.saveCheckpoint(checkpoint)
 	}
 
-	for _, dataShipper := range data.Shippers {
-		fmt.Println("Hello shipper", dataShipper.CompanyName)
-		shipper := common.Shipper(*dataShipper)
-		err := g.repository.StoreShipper(&shipper)
-		if err != nil {
-			log.WithError(err).WithField("company_name", shipper.CompanyName).Errorf("cannot store shipper")
+	err := g.read(func(entity string, record Record) error {
+		read[entity]++
+		if checker != nil {
+			// Rows loaded before are checked as well, later rows may refer to them
+			checker.check(record)
+		}
+		if read[entity] <= resume[entity] {
+			if read[entity] == 1 {
+				log.WithFields(log.Fields{
+					"entity": entity,
+					"offset": resume[entity],
+				}).Info("Resuming entity from checkpoint")
+			}
+			return nil
 		}
-	}
 
-	for _, dataSupplier := range data.Suppliers {
-		fmt.Println("Hello supplier", dataSupplier.CompanyName)
-		supplier := common.Supplier(*dataSupplier)
-		err := g.repository.StoreSupplier(&supplier)
+		job, err := newJob(record.Row)
+		if err == nil {
+			err = malformedError(record)
+		}
 		if err != nil {
-			log.WithError(err).WithField("company_name", supplier.CompanyName).Errorf("cannot store supplier")
+			unparsed++
+			atomic.AddInt64(&progress.failed, 1)
+			log.WithFields(log.Fields{
+				"file": record.File,
+				"row":  record.Line,
+			}).Errorf("cannot parse row: %v", err)
+			return nil
+		}
+		pool.queue <- job
+		queued++
+		if queued >= g.config.CheckpointRows {
+			writeErr = barrier()
+		}
+		return writeErr
+	}, func(entity string) error {
+		if checker == nil {
+			return nil
 		}
+		checker.finish(entity)
+		if !lastOfPhase(entity) {
+			return nil
+		}
+		writeErr = barrier()
+		return writeErr
+	})
+	if err == nil {
+		err = barrier()
 	}
-
-	return nil
-}
-
-func (g *Loader) loadData() (*LoaderData, error) {
-	var categories []*Category
-	err := g.loadCSV(path.Join(g.csvDirectory, "categories.csv"), &categories)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing categories file %v", err)
+	pool.wait(progress)
+	if writeErr != nil {
+		return unparsed, fmt.Errorf("could not load rows: %v", writeErr)
 	}
-
-	var customers []*Customer
-	err = g.loadCSV(path.Join(g.csvDirectory, "customers.csv"), &customers)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing customers file %v", err)
+		return unparsed, err
 	}
-
-	var employees []*Employee
-	err = g.loadCSV(path.Join(g.csvDirectory, "employees.csv"), &employees)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing employees file %v", err)
+	if len(kinds) > 0 {
+		logViolationSummary(kinds)
 	}
 
-	var orderDetails []*OrderDetail
-	err = g.loadCSV(path.Join(g.csvDirectory, "order_details.csv"), &orderDetails)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing order_details file %v", err)
+	checkpoint.Completed = true
+	return unparsed, g.saveCheckpoint(checkpoint)
+}
+
+// read reads the entities of the source in dependency order. It calls fn with every record, whose null tokens are
+// cleared, and done after the last record of every entity.
+func (g *Loader) read(fn func(entity string, record Record) error, done func(entity string) error) error {
+	nullTokens := map[string]bool{}
+	for _, token := range g.config.NullTokens {
+		nullTokens[token] = true
 	}
 
-	var orders []*Order
-	err = g.loadCSV(path.Join(g.csvDirectory, "orders.csv"), &orders)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing orders file %v", err)
+	embeddedDetails := false
+	for _, entity := range Entities {
+		err := g.source.Read(entity, func(record Record) error {
+			if _, ok := record.Row.(*OrderDetail); ok && entity == EntityOrders {
+				embeddedDetails = true
+			}
+			clearNullTokens(record.Row, nullTokens)
+			for name, value := range record.Malformed {
+				if nullTokens[value] {
+					delete(record.Malformed, name)
+				}
+			}
+			return fn(entity, record)
+		})
+		if err == ErrEntityNotFound && entity == EntityOrderDetails && embeddedDetails {
+			// The order details were embedded into the orders
+			err = nil
+		}
+		if err == ErrEntityNotFound {
+			return fmt.Errorf("%v holds no %v", g.source, entity)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", entity, err)
+		}
+		err = done(entity)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	var products []*Product
-	err = g.loadCSV(path.Join(g.csvDirectory, "products.csv"), &products)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing products file %v", err)
+// newJob parses a row into the model and returns the job storing it
+func newJob(row interface{}) (loadJob, error) {
+	switch row := row.(type) {
+	case *Category:
+		category, err := row.model()
+		return loadJob{fmt.Sprintf("category %v", category.CategoryName), func(repository *common.Repository) error {
+			return repository.StoreCategory(category)
+		}}, err
+	case *Customer:
+		customer, err := row.model()
+		return loadJob{fmt.Sprintf("customer %v", customer.CustomerID), func(repository *common.Repository) error {
+			return repository.StoreCustomer(customer)
+		}}, err
+	case *Employee:
+		employee, err := row.model()
+		return loadJob{fmt.Sprintf("employee %v", employee.EmployeeID), func(repository *common.Repository) error {
+			return repository.StoreEmployee(employee)
+		}}, err
+	case *OrderDetail:
+		orderDetail, err := row.model()
+		return loadJob{fmt.Sprintf("order detail %v/%v", orderDetail.OrderID, orderDetail.ProductID), func(repository *common.Repository) error {
+			return repository.StoreOrderDetail(orderDetail)
+		}}, err
+	case *Order:
+		order, err := row.model()
+		return loadJob{fmt.Sprintf("order %v", order.OrderID), func(repository *common.Repository) error {
+			return repository.StoreOrder(order)
+		}}, err
+	case *Product:
+		product, err := row.model()
+		return loadJob{fmt.Sprintf("product %v", product.ProductID), func(repository *common.Repository) error {
+			return repository.StoreProduct(product)
+		}}, err
+	case *Shipper:
+		shipper, err := row.model()
+		return loadJob{fmt.Sprintf("shipper %v", shipper.ShipperID), func(repository *common.Repository) error {
+			return repository.StoreShipper(shipper)
+		}}, err
+	case *Supplier:
+		supplier, err := row.model()
+		return loadJob{fmt.Sprintf("supplier %v", supplier.SupplierID), func(repository *common.Repository) error {
+			return repository.StoreSupplier(supplier)
+		}}, err
 	}
+	return loadJob{}, fmt.Errorf("unknown record type %T", row)
+}
 
-	var shippers []*Shipper
-	err = g.loadCSV(path.Join(g.csvDirectory, "shippers.csv"), &shippers)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing shippers file %v", err)
+// startPool starts the workers of a pool writing the jobs of a new queue
+func (g *Loader) startPool(progress *loadProgress) *writerPool {
+	pool := &writerPool{queue: make(chan loadJob, g.config.QueueSize)}
+	for i := 0; i < g.config.Workers; i++ {
+		pool.wg.Add(1)
+		go func(worker int) {
+			defer pool.wg.Done()
+			repository := g.repository.Batch(common.NewRateLimiter(g.config.WorkerWriteRate))
+			repository.SetNewItems(g.newItems)
+			// A failed batch fails the rows of all of its items, every row is counted once
+			failedRows := map[string]bool{}
+			fail := func(rows map[string]error) {
+				for row, err := range rows {
+					if failedRows[row] {
+						continue
+					}
+					failedRows[row] = true
+					atomic.AddInt64(&pool.failed, 1)
+					log.WithError(err).WithField("worker", worker).Errorf("cannot store %v", row)
+				}
+			}
+
+			var lastWritten int64
+			for job := range pool.queue {
+				repository.SetRow(job.description)
+				err := job.store(repository)
+				rows := repository.FailedRows()
+				if err != nil && len(rows) == 0 {
+					// The row could not be stored before any of its items were written
+					rows = map[string]error{job.description: err}
+				}
+				fail(rows)
+				atomic.AddInt64(&progress.rows, 1)
+				atomic.AddInt64(&progress.written, repository.Written()-lastWritten)
+				lastWritten = repository.Written()
+			}
+
+			repository.Flush()
+			fail(repository.FailedRows())
+			atomic.AddInt64(&progress.written, repository.Written()-lastWritten)
+		}(i)
 	}
+	return pool
+}
 
-	var suppliers []*Supplier
-	err = g.loadCSV(path.Join(g.csvDirectory, "suppliers.csv"), &suppliers)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing suppliers file %v", err)
+// wait closes the queue and returns once all queued jobs are written
+func (p *writerPool) wait(progress *loadProgress) error {
+	close(p.queue)
+	p.wg.Wait()
+
+	atomic.AddInt64(&progress.failed, p.failed)
+	if p.failed > 0 {
+		return fmt.Errorf("%v rows could not be stored", p.failed)
 	}
+	return nil
+}
 
-	return &LoaderData{
-		Categories:   categories,
-		Customers:    customers,
-		Employees:    employees,
-		OrderDetails: orderDetails,
-		Orders:       orders,
-		Products:     products,
-		Shippers:     shippers,
-		Suppliers:    suppliers,
-	}, nil
+func (g *Loader) report(message string, progress *loadProgress) {
+	rows, written := atomic.LoadInt64(&progress.rows), atomic.LoadInt64(&progress.written)
+	elapsed := time.Since(progress.start)
+	log.WithFields(log.Fields{
+		"rows":          rows,
+		"failed":        atomic.LoadInt64(&progress.failed),
+		"items":         written,
+		"elapsed":       elapsed.Round(time.Second),
+		"rows_per_sec":  int64(float64(rows) / elapsed.Seconds()),
+		"items_per_sec": int64(float64(written) / elapsed.Seconds()),
+	}).Info(message)
 }
 
-func (g *Loader) loadCSV(filename string, out interface{}) error {
-	file, err := os.OpenFile(filename, os.O_RDONLY, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("could not read file %v: %v", filename, err)
+// malformedError returns the parse errors of the integer columns of a record which are not integers
+func malformedError(record Record) error {
+	if len(record.Malformed) == 0 {
+		return nil
 	}
-	if file == nil {
-		return fmt.Errorf("")
+	var parseErrors ParseErrors
+	for name, value := range record.Malformed {
+		parseErrors = append(parseErrors, fmt.Sprintf("%v %q is not an integer", name, value))
 	}
-	defer Close(file)
+	sort.Strings(parseErrors)
+	return parseErrors
+}
 
-	err = gocsv.UnmarshalFile(file, out)
-	if err != nil {
-		return fmt.Errorf("could not parse CSV file %v: %v", filename, err)
+// clearNullTokens replaces the null tokens in the string fields of a row with empty strings
+func clearNullTokens(row interface{}, nullTokens map[string]bool) {
+	value := reflect.ValueOf(row).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() == reflect.String && nullTokens[field.String()] {
+			field.SetString("")
+		}
 	}
-
-	return nil
 }
 
 func Close(c io.Closer) {
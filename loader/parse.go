@@ -0,0 +1,192 @@
+package loader
+
+import (
+	"fmt"
+	"github.com/fstehle/dynamodb-single-table-example/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const csvDateLayout = "2006-01-02 15:04:05.000"
+
+// ParseErrors lists the fields of a row which cannot be parsed
+type ParseErrors []string
+
+func (e ParseErrors) Error() string {
+	return strings.Join(e, ", ")
+}
+
+// rowParser parses the typed fields of a CSV row and collects the errors of all fields. Empty values, which includes
+// cleared null tokens, parse to a missing value: a missing decimal, nil or the zero value.
+type rowParser struct {
+	errors ParseErrors
+}
+
+func (p *rowParser) fail(field string, value string, kind string) {
+	p.errors = append(p.errors, fmt.Sprintf("%v %q is not %v", field, value, kind))
+}
+
+func (p *rowParser) decimal(field string, value string) common.Decimal {
+	if value == "" {
+		return common.Decimal{}
+	}
+	decimal, err := common.ParseDecimal(value)
+	if err != nil {
+		p.fail(field, value, "a decimal number")
+	}
+	return decimal
+}
+
+func (p *rowParser) integer(field string, value string) *int {
+	if value == "" {
+		return nil
+	}
+	integer, err := strconv.Atoi(value)
+	if err != nil {
+		p.fail(field, value, "an integer")
+		return nil
+	}
+	return &integer
+}
+
+func (p *rowParser) boolean(field string, value string) *bool {
+	if value == "" {
+		return nil
+	}
+	boolean, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(field, value, "a boolean")
+		return nil
+	}
+	return &boolean
+}
+
+func (p *rowParser) date(field string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	date, err := time.Parse(csvDateLayout, value)
+	if err != nil {
+		// JSON exports write dates in RFC 3339
+		date, err = time.Parse(time.RFC3339Nano, value)
+	}
+	if err != nil {
+		p.fail(field, value, "a date")
+	}
+	return date
+}
+
+func (p *rowParser) binary(field string, value string) []byte {
+	if value == "" {
+		return nil
+	}
+	data, err := common.ParseHexBinary(value)
+	if err != nil {
+		p.errors = append(p.errors, fmt.Sprintf("%v is not a hex encoded binary value: %v", field, err))
+	}
+	return data
+}
+
+func (p *rowParser) err() error {
+	if len(p.errors) == 0 {
+		return nil
+	}
+	return p.errors
+}
+
+func (c *Category) model() (*common.Category, error) {
+	parser := &rowParser{}
+	return &common.Category{
+		CategoryID:   c.CategoryID,
+		CategoryName: c.CategoryName,
+		Description:  c.Description,
+		Picture:      parser.binary("picture", c.Picture),
+	}, parser.err()
+}
+
+func (c *Customer) model() (*common.Customer, error) {
+	customer := common.Customer(*c)
+	return &customer, nil
+}
+
+func (e *Employee) model() (*common.Employee, error) {
+	parser := &rowParser{}
+	return &common.Employee{
+		EmployeeID:      e.EmployeeID,
+		LastName:        e.LastName,
+		FirstName:       e.FirstName,
+		Title:           e.Title,
+		TitleOfCourtesy: e.TitleOfCourtesy,
+		BirthDate:       parser.date("birthDate", e.BirthDate),
+		HireDate:        parser.date("hireDate", e.HireDate),
+		Address:         e.Address,
+		City:            e.City,
+		Region:          e.Region,
+		PostalCode:      e.PostalCode,
+		Country:         e.Country,
+		HomePhone:       e.HomePhone,
+		Extension:       e.Extension,
+		Photo:           parser.binary("photo", e.Photo),
+		Notes:           e.Notes,
+		ReportsTo:       e.ReportsTo,
+		PhotoPath:       e.PhotoPath,
+	}, parser.err()
+}
+
+func (o *OrderDetail) model() (*common.OrderDetail, error) {
+	parser := &rowParser{}
+	return &common.OrderDetail{
+		OrderID:   o.OrderID,
+		ProductID: o.ProductID,
+		UnitPrice: parser.decimal("unitPrice", o.UnitPrice),
+		Quantity:  parser.integer("quantity", o.Quantity),
+		Discount:  parser.decimal("discount", o.Discount),
+	}, parser.err()
+}
+
+func (o *Order) model() (*common.Order, error) {
+	parser := &rowParser{}
+	return &common.Order{
+		OrderID:        o.OrderID,
+		CustomerID:     o.CustomerID,
+		EmployeeID:     o.EmployeeID,
+		OrderDate:      parser.date("orderDate", o.OrderDate),
+		RequiredDate:   parser.date("requiredDate", o.RequiredDate),
+		ShippedDate:    parser.date("shippedDate", o.ShippedDate),
+		ShipVia:        o.ShipVia,
+		Freight:        parser.decimal("freight", o.Freight),
+		ShipName:       o.ShipName,
+		ShipAddress:    o.ShipAddress,
+		ShipCity:       o.ShipCity,
+		ShipRegion:     o.ShipRegion,
+		ShipPostalCode: o.ShipPostalCode,
+		ShipCountry:    o.ShipCountry,
+	}, parser.err()
+}
+
+func (p *Product) model() (*common.Product, error) {
+	parser := &rowParser{}
+	return &common.Product{
+		ProductID:       p.ProductID,
+		ProductName:     p.ProductName,
+		SupplierID:      p.SupplierID,
+		CategoryID:      p.CategoryID,
+		QuantityPerUnit: p.QuantityPerUnit,
+		UnitPrice:       parser.decimal("unitPrice", p.UnitPrice),
+		UnitsInStock:    parser.integer("unitsInStock", p.UnitsInStock),
+		UnitsOnOrder:    parser.integer("unitsOnOrder", p.UnitsOnOrder),
+		ReorderLevel:    parser.integer("reorderLevel", p.ReorderLevel),
+		Discontinued:    parser.boolean("discontinued", p.Discontinued),
+	}, parser.err()
+}
+
+func (s *Shipper) model() (*common.Shipper, error) {
+	shipper := common.Shipper(*s)
+	return &shipper, nil
+}
+
+func (s *Supplier) model() (*common.Supplier, error) {
+	supplier := common.Supplier(*s)
+	return &supplier, nil
+}
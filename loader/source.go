@@ -0,0 +1,482 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+)
+
+// Formats lists the input formats in the order the loader looks for the files of an entity
+var Formats = []string{FormatCSV, FormatJSON, FormatJSONL}
+
+const (
+	EntityCategories   = "categories"
+	EntityCustomers    = "customers"
+	EntityEmployees    = "employees"
+	EntityOrders       = "orders"
+	EntityOrderDetails = "order_details"
+	EntityProducts     = "products"
+	EntityShippers     = "shippers"
+	EntitySuppliers    = "suppliers"
+)
+
+// phases group the entities in dependency order, rows refer only to rows of earlier phases and of their own entity.
+// Order details may be embedded into the orders.
+var phases = [][]string{
+	{EntityCategories, EntityCustomers, EntityEmployees, EntityShippers, EntitySuppliers},
+	{EntityProducts},
+	{EntityOrders},
+	{EntityOrderDetails},
+}
+
+// Entities lists the entities in the order the loader reads them, phase by phase
+var Entities = func() []string {
+	var entities []string
+	for _, phase := range phases {
+		entities = append(entities, phase...)
+	}
+	return entities
+}()
+
+// lastOfPhase reports whether the entity completes its phase
+func lastOfPhase(entity string) bool {
+	for _, phase := range phases {
+		if phase[len(phase)-1] == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultNullToken marks missing values in the Northwind CSV files
+const DefaultNullToken = "NULL"
+
+// ErrEntityNotFound is returned by a source which holds no data for an entity
+var ErrEntityNotFound = errors.New("entity not found")
+
+// Record is a row of the source data with the file and line it was read from. Row is a *Category, *Customer,
+// *Employee, *Order, *OrderDetail, *Product, *Shipper or *Supplier.
+type Record struct {
+	Row  interface{}
+	File string
+	Line int
+	// Malformed holds the values of integer columns which are not integers by column name. The loader clears the
+	// null tokens among them and reports the others as malformed values.
+	Malformed map[string]string
+}
+
+// Source provides the source data of the loader. Read calls fn with the records of an entity in source order and
+// returns ErrEntityNotFound if the source has no data for it. Reading orders may yield the order details embedded into
+// them as well. String identifies the source in logs and checkpoints.
+type Source interface {
+	Read(entity string, fn func(record Record) error) error
+	String() string
+}
+
+// Fingerprinter is implemented by sources which can tell apart versions of their data. Checkpoints record the
+// fingerprint, so that a load is only resumed with the data it was started with.
+type Fingerprinter interface {
+	Fingerprint() (string, error)
+}
+
+// DirectorySource reads the files <entity>.csv, <entity>.json or <entity>.jsonl of a directory. If format is empty,
+// it reads the first of these files which exists.
+type DirectorySource struct {
+	directory string
+	format    string
+}
+
+func NewDirectorySource(directory string, format string) *DirectorySource {
+	return &DirectorySource{
+		directory: directory,
+		format:    format,
+	}
+}
+
+func (s *DirectorySource) String() string {
+	return s.directory
+}
+
+func (s *DirectorySource) Read(entity string, fn func(record Record) error) error {
+	for _, format := range formats(s.format) {
+		filename := path.Join(s.directory, entity+"."+format)
+		file, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read file %v: %v", filename, err)
+		}
+		defer Close(file)
+		return readRecords(file, path.Base(filename), format, entity, fn)
+	}
+	return ErrEntityNotFound
+}
+
+// Fingerprint hashes the names, sizes and modification times of the data files
+func (s *DirectorySource) Fingerprint() (string, error) {
+	hash := sha256.New()
+	for _, entity := range Entities {
+		for _, format := range formats(s.format) {
+			err := writeFileInfo(hash, path.Join(s.directory, entity+"."+format))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ArchiveSource reads the data files from a tar, gzip compressed tar or zip archive, wherever they are located in
+// the archive. The kind of archive is detected from its content.
+type ArchiveSource struct {
+	filename string
+	format   string
+}
+
+func NewArchiveSource(filename string, format string) *ArchiveSource {
+	return &ArchiveSource{
+		filename: filename,
+		format:   format,
+	}
+}
+
+func (s *ArchiveSource) String() string {
+	return s.filename
+}
+
+func (s *ArchiveSource) Read(entity string, fn func(record Record) error) error {
+	file, err := os.Open(s.filename)
+	if err != nil {
+		return fmt.Errorf("could not read archive %v: %v", s.filename, err)
+	}
+	defer Close(file)
+	return readArchive(file, s.format, entity, fn)
+}
+
+// Fingerprint hashes the name, size and modification time of the archive
+func (s *ArchiveSource) Fingerprint() (string, error) {
+	hash := sha256.New()
+	err := writeFileInfo(hash, s.filename)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// StdinSource reads an archive like ArchiveSource from standard input. The loader reads the entities in its own
+// order, so the input is spooled to a temporary file on the first read.
+type StdinSource struct {
+	format string
+	stdin  io.Reader
+	spool  *os.File
+}
+
+func NewStdinSource(format string) *StdinSource {
+	return &StdinSource{
+		format: format,
+		stdin:  os.Stdin,
+	}
+}
+
+func (s *StdinSource) String() string {
+	return "stdin"
+}
+
+func (s *StdinSource) Read(entity string, fn func(record Record) error) error {
+	err := s.spoolStdin()
+	if err != nil {
+		return err
+	}
+	return readArchive(s.spool, s.format, entity, fn)
+}
+
+// Fingerprint hashes the content of the input, stdin has no name or modification time to tell inputs apart
+func (s *StdinSource) Fingerprint() (string, error) {
+	err := s.spoolStdin()
+	if err != nil {
+		return "", err
+	}
+	info, err := s.spool.Stat()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, io.NewSectionReader(s.spool, 0, info.Size()))
+	if err != nil {
+		return "", fmt.Errorf("could not read spool file: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *StdinSource) spoolStdin() error {
+	if s.spool != nil {
+		return nil
+	}
+	spool, err := ioutil.TempFile("", "ddb-single-table-stdin-")
+	if err != nil {
+		return fmt.Errorf("could not create spool file: %v", err)
+	}
+	// The open file stays readable, removing it early leaves nothing behind if the process exits
+	os.Remove(spool.Name())
+	_, err = io.Copy(spool, s.stdin)
+	if err != nil {
+		Close(spool)
+		return fmt.Errorf("could not read stdin: %v", err)
+	}
+	s.spool = spool
+	return nil
+}
+
+func (s *StdinSource) Close() error {
+	if s.spool == nil {
+		return nil
+	}
+	return s.spool.Close()
+}
+
+// writeFileInfo writes the name, size and modification time of a file
+func writeFileInfo(w io.Writer, filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%v %v %v\n", path.Base(filename), info.Size(), info.ModTime().UnixNano())
+	return err
+}
+
+// formats returns the formats to look for, all of them in order if format is empty
+func formats(format string) []string {
+	if format == "" {
+		return Formats
+	}
+	return []string{format}
+}
+
+// findEntry returns the entry of an archive holding an entity, preferring the formats in order
+func findEntry(names []string, entity string, format string) (string, string) {
+	for _, format := range formats(format) {
+		for _, name := range names {
+			if path.Base(name) == entity+"."+format {
+				return name, format
+			}
+		}
+	}
+	return "", ""
+}
+
+func readArchive(file *os.File, format string, entity string, fn func(record Record) error) error {
+	magic := make([]byte, 4)
+	n, _ := file.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	if bytes.HasPrefix(magic, []byte("PK\x03\x04")) {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		archive, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return fmt.Errorf("could not read zip archive: %v", err)
+		}
+		var names []string
+		for _, entry := range archive.File {
+			if entry.Mode().IsRegular() {
+				names = append(names, entry.Name)
+			}
+		}
+		name, entryFormat := findEntry(names, entity, format)
+		for _, entry := range archive.File {
+			if entry.Name != name || !entry.Mode().IsRegular() {
+				continue
+			}
+			reader, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("could not read %v: %v", name, err)
+			}
+			defer Close(reader)
+			return readRecords(reader, name, entryFormat, entity, fn)
+		}
+		return ErrEntityNotFound
+	}
+
+	gzipped := bytes.HasPrefix(magic, []byte{0x1f, 0x8b})
+	var names []string
+	err := readTar(file, gzipped, func(header *tar.Header, reader io.Reader) (bool, error) {
+		if header.FileInfo().Mode().IsRegular() {
+			names = append(names, header.Name)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not read tar archive: %v", err)
+	}
+	name, entryFormat := findEntry(names, entity, format)
+	if name == "" {
+		return ErrEntityNotFound
+	}
+	return readTar(file, gzipped, func(header *tar.Header, reader io.Reader) (bool, error) {
+		if header.Name != name || !header.FileInfo().Mode().IsRegular() {
+			return false, nil
+		}
+		return true, readRecords(reader, name, entryFormat, entity, fn)
+	})
+}
+
+// readTar calls visit with every entry of a tar archive from the start of the file until visit returns true
+func readTar(file *os.File, gzipped bool, visit func(header *tar.Header, reader io.Reader) (bool, error)) error {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = file
+	if gzipped {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer Close(gzipReader)
+		reader = gzipReader
+	}
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done, err := visit(header, archive)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// recordEntity returns the entity of a row
+func recordEntity(row interface{}) string {
+	switch row.(type) {
+	case *Category:
+		return EntityCategories
+	case *Customer:
+		return EntityCustomers
+	case *Employee:
+		return EntityEmployees
+	case *Order:
+		return EntityOrders
+	case *OrderDetail:
+		return EntityOrderDetails
+	case *Product:
+		return EntityProducts
+	case *Shipper:
+		return EntityShippers
+	case *Supplier:
+		return EntitySuppliers
+	}
+	return ""
+}
+
+// newRow returns an empty row of an entity
+func newRow(entity string) (interface{}, error) {
+	switch entity {
+	case EntityCategories:
+		return &Category{}, nil
+	case EntityCustomers:
+		return &Customer{}, nil
+	case EntityEmployees:
+		return &Employee{}, nil
+	case EntityOrders:
+		return &Order{}, nil
+	case EntityOrderDetails:
+		return &OrderDetail{}, nil
+	case EntityProducts:
+		return &Product{}, nil
+	case EntityShippers:
+		return &Shipper{}, nil
+	case EntitySuppliers:
+		return &Supplier{}, nil
+	}
+	return nil, fmt.Errorf("unknown entity %v", entity)
+}
+
+// readRecords reads the rows of an entity from a file in the given format. The line of a CSV row is its line in the
+// file behind the header.
+func readRecords(reader io.Reader, file string, format string, entity string, fn func(record Record) error) error {
+	read := func(values map[string]interface{}, line int) error {
+		row, err := newRow(entity)
+		if err != nil {
+			return err
+		}
+		malformed, err := decodeRow(values, reflect.ValueOf(row).Elem())
+		if err != nil {
+			return fmt.Errorf("could not parse %v:%v: %v", file, line, err)
+		}
+		err = fn(Record{Row: row, File: file, Line: line, Malformed: malformed})
+		if err != nil || entity != EntityOrders {
+			return err
+		}
+
+		details, err := embeddedOrderDetails(values, file, line)
+		if err != nil {
+			return fmt.Errorf("could not parse %v:%v: %v", file, line, err)
+		}
+		for _, detail := range details {
+			err = fn(detail)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if format != FormatCSV {
+		return readDocuments(reader, file, format, func(document document) error {
+			return read(document.values, document.line)
+		})
+	}
+
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("could not parse CSV file %v: %v", file, err)
+	}
+	for line := 2; ; line++ {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not parse CSV file %v: %v", file, err)
+		}
+		values := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			values[name] = fields[i]
+		}
+		err = read(values, line)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package loader
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+const (
+	ViolationOrphanedReference = "orphaned reference"
+	ViolationDuplicateKey      = "duplicate key"
+	ViolationMalformedValue    = "malformed value"
+)
+
+// Violation is a problem of a row of the source data. Row is the line number in CSV and JSONL files and the number of
+// the document in JSON arrays.
+type Violation struct {
+	File    string
+	Row     int
+	Kind    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v:%v: %v: %v", v.File, v.Row, v.Kind, v.Message)
+}
+
+// Validate reads the source data and checks that the references between the entities point at existing rows, that
+// primary keys are unique and that the rows can be parsed into the model
+func (g *Loader) Validate() ([]Violation, error) {
+	var violations []Violation
+	checker := newValidator(func(violation Violation) {
+		violations = append(violations, violation)
+	}, true)
+	err := g.read(func(entity string, record Record) error {
+		checker.check(record)
+		return nil
+	}, func(entity string) error {
+		checker.finish(entity)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read data: %v", err)
+	}
+	return violations, nil
+}
+
+// LogViolations logs every violation and a summary per kind
+func LogViolations(violations []Violation) {
+	kinds := map[string]int{}
+	for _, violation := range violations {
+		kinds[violation.Kind]++
+		logViolation(violation)
+	}
+	logViolationSummary(kinds)
+}
+
+func logViolation(violation Violation) {
+	log.WithFields(log.Fields{
+		"file": violation.File,
+		"row":  violation.Row,
+		"kind": violation.Kind,
+	}).Warn(violation.Message)
+}
+
+func logViolationSummary(kinds map[string]int) {
+	log.WithFields(log.Fields{
+		"violations":          kinds[ViolationOrphanedReference] + kinds[ViolationDuplicateKey] + kinds[ViolationMalformedValue],
+		"orphaned_references": kinds[ViolationOrphanedReference],
+		"duplicate_keys":      kinds[ViolationDuplicateKey],
+		"malformed_values":    kinds[ViolationMalformedValue],
+	}).Info("Validated data")
+}
+
+// validator checks the rows of the entities in dependency order. It keeps the keys of the rows read so far, so that
+// references to entities of earlier phases can be checked immediately. Order IDs are kept in a bitset, one bit per
+// ID. With exactDetails the keys of all order details are kept to find every duplicate, the checker running while
+// loading only checks the consecutive details of an order for duplicates, so that its memory does not grow with the
+// number of order details.
+type validator struct {
+	file      string
+	line      int
+	report    func(violation Violation)
+	keys      map[string]map[string]bool
+	orderIDs  intSet
+	reportsTo []pendingReference
+	// detailKeys holds the order and product ID of every order detail read if details are checked exactly
+	detailKeys map[uint64]bool
+	// detailsOrderID and detailsProductIDs are the order and products of the last order details read
+	detailsOrderID    int
+	detailsProductIDs map[int]bool
+}
+
+// pendingReference is a reference to a row of the same entity, which may follow the referring row
+type pendingReference struct {
+	file string
+	line int
+	key  string
+}
+
+func newValidator(report func(violation Violation), exactDetails bool) *validator {
+	v := &validator{
+		report:   report,
+		keys:     map[string]map[string]bool{},
+		orderIDs: intSet{},
+	}
+	if exactDetails {
+		v.detailKeys = map[uint64]bool{}
+	}
+	return v
+}
+
+// check reports the violations of a record
+func (v *validator) check(record Record) {
+	v.file, v.line = record.File, record.Line
+	v.parse(nil, malformedError(record))
+	switch row := record.Row.(type) {
+	case *Category:
+		v.unique(EntityCategories, "category", strconv.Itoa(row.CategoryID))
+		v.parse(row.model())
+	case *Customer:
+		v.unique(EntityCustomers, "customer", row.CustomerID)
+	case *Employee:
+		v.unique(EntityEmployees, "employee", strconv.Itoa(row.EmployeeID))
+		v.parse(row.model())
+		if !isNull(row.ReportsTo) {
+			v.reportsTo = append(v.reportsTo, pendingReference{record.File, record.Line, row.ReportsTo})
+		}
+	case *Shipper:
+		v.unique(EntityShippers, "shipper", strconv.Itoa(row.ShipperID))
+	case *Supplier:
+		v.unique(EntitySuppliers, "supplier", strconv.Itoa(row.SupplierID))
+	case *Product:
+		v.unique(EntityProducts, "product", strconv.Itoa(row.ProductID))
+		v.reference(EntitySuppliers, "supplierID", "supplier", referenceKey(row.SupplierID))
+		v.reference(EntityCategories, "categoryID", "category", referenceKey(row.CategoryID))
+		v.parse(row.model())
+	case *Order:
+		if v.orderIDs.has(row.OrderID) {
+			v.add(ViolationDuplicateKey, "order %v exists more than once", row.OrderID)
+		}
+		v.orderIDs.add(row.OrderID)
+		v.reference(EntityCustomers, "customerID", "customer", row.CustomerID)
+		v.reference(EntityEmployees, "employeeID", "employee", referenceKey(row.EmployeeID))
+		v.reference(EntityShippers, "shipVia", "shipper", row.ShipVia)
+		v.parse(row.model())
+	case *OrderDetail:
+		v.uniqueDetail(row.OrderID, row.ProductID)
+		if !v.orderIDs.has(row.OrderID) {
+			v.add(ViolationOrphanedReference, "orderID refers to unknown order %v", row.OrderID)
+		}
+		v.reference(EntityProducts, "productID", "product", referenceKey(row.ProductID))
+		v.parse(row.model())
+	}
+}
+
+// finish checks the references to rows of an entity which were read completely
+func (v *validator) finish(entity string) {
+	if entity != EntityEmployees {
+		return
+	}
+	for _, reference := range v.reportsTo {
+		v.file, v.line = reference.file, reference.line
+		v.reference(EntityEmployees, "reportsTo", "employee", reference.key)
+	}
+	v.reportsTo = nil
+}
+
+func (v *validator) add(kind string, format string, args ...interface{}) {
+	v.report(Violation{
+		File:    v.file,
+		Row:     v.line,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) unique(entity string, name string, key string) {
+	keys := v.keys[entity]
+	if keys == nil {
+		keys = map[string]bool{}
+		v.keys[entity] = keys
+	}
+	if keys[key] {
+		v.add(ViolationDuplicateKey, "%v %v exists more than once", name, key)
+	}
+	keys[key] = true
+}
+
+// uniqueDetail checks that a product appears only once in the details of an order, or among the consecutive details
+// of an order if details are not checked exactly
+func (v *validator) uniqueDetail(orderID int, productID int) {
+	if v.detailKeys != nil {
+		key := uint64(uint32(orderID))<<32 | uint64(uint32(productID))
+		if v.detailKeys[key] {
+			v.add(ViolationDuplicateKey, "order detail %v/%v exists more than once", orderID, productID)
+		}
+		v.detailKeys[key] = true
+		return
+	}
+	if v.detailsProductIDs == nil || orderID != v.detailsOrderID {
+		v.detailsOrderID, v.detailsProductIDs = orderID, map[int]bool{}
+	}
+	if v.detailsProductIDs[productID] {
+		v.add(ViolationDuplicateKey, "order detail %v/%v exists more than once", orderID, productID)
+	}
+	v.detailsProductIDs[productID] = true
+}
+
+// reference checks that a non-empty reference points at an existing key
+func (v *validator) reference(entity string, field string, name string, key string) {
+	if isNull(key) {
+		return
+	}
+	if !v.keys[entity][key] {
+		v.add(ViolationOrphanedReference, "%v refers to unknown %v %v", field, name, key)
+	}
+}
+
+// parse reports every field of a row which cannot be parsed into the model
+func (v *validator) parse(model interface{}, err error) {
+	if parseErrors, ok := err.(ParseErrors); ok {
+		for _, parseError := range parseErrors {
+			v.add(ViolationMalformedValue, "%v", parseError)
+		}
+	} else if err != nil {
+		v.add(ViolationMalformedValue, "%v", err)
+	}
+}
+
+// intSet is a bitset of integers in pages of 64Ki bits, so that dense IDs take one bit each
+type intSet map[int][]uint64
+
+const intSetPageBits = 16
+
+func (s intSet) add(n int) {
+	page := s[n>>intSetPageBits]
+	if page == nil {
+		page = make([]uint64, 1<<intSetPageBits/64)
+		s[n>>intSetPageBits] = page
+	}
+	bit := n & (1<<intSetPageBits - 1)
+	page[bit/64] |= 1 << uint(bit%64)
+}
+
+func (s intSet) has(n int) bool {
+	page := s[n>>intSetPageBits]
+	if page == nil {
+		return false
+	}
+	bit := n & (1<<intSetPageBits - 1)
+	return page[bit/64]&(1<<uint(bit%64)) != 0
+}
+
+// referenceKey returns the key of an integer reference, an empty key for a missing reference, which parses to 0
+func referenceKey(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
+// isNull reports whether a CSV value is missing, null tokens are already cleared when the data is loaded
+func isNull(value string) bool {
+	return value == ""
+}